@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// arrayZone is one flat-ish patch of cells on the car (top shell, nose slope,
+// canopy, ...). Tilt and azimuth are relative to the car body: a zone with
+// TiltDeg 0 lies flat on the body, and AzimuthDeg 0 faces the same way the
+// body's chargeWindow azimuth points (positive turns toward the west when the
+// body faces south, matching the Open-Meteo convention).
+type arrayZone struct {
+	Name       string  `json:"name"`
+	Area       float64 `json:"area"`       // m²
+	CellEff    float64 `json:"cellEff"`    // 0..1 at STC
+	TiltDeg    float64 `json:"tiltDeg"`    // relative to the body
+	AzimuthDeg float64 `json:"azimuthDeg"` // relative to the body
+}
+
+// zoneEnergy is the energy one zone collected over a window.
+type zoneEnergy struct {
+	Name       string  `json:"name"`
+	TiltDeg    float64 `json:"tiltDeg"`    // absolute, after applying body orientation
+	AzimuthDeg float64 `json:"azimuthDeg"` // absolute, after applying body orientation
+	EnergyWh   float64 `json:"energyWh"`
+}
+
+// arrayZones returns the site's zones, or a single body-aligned zone built
+// from PanelArea/PanelEff when no zones are configured.
+func (site solarSite) arrayZones() []arrayZone {
+	if len(site.Zones) > 0 {
+		return site.Zones
+	}
+	return []arrayZone{{Name: "array", Area: site.PanelArea, CellEff: site.PanelEff}}
+}
+
+// azimuthDirection is the horizontal unit vector (east, north) an Open-Meteo
+// azimuth faces: 0=south, -90=east, 90=west.
+func azimuthDirection(azimuthDeg float64) (float64, float64) {
+	az := azimuthDeg * math.Pi / 180
+	return -math.Sin(az), -math.Cos(az)
+}
+
+// zoneOrientation combines the body orientation (bodyTilt tipped toward
+// bodyAzimuth) with a zone's body-relative tilt/azimuth and returns the
+// zone's absolute tilt and azimuth in degrees.
+func zoneOrientation(bodyTiltDeg, bodyAzimuthDeg, zoneTiltDeg, zoneAzimuthDeg float64) (float64, float64) {
+	if zoneTiltDeg == 0 && zoneAzimuthDeg == 0 {
+		return bodyTiltDeg, bodyAzimuthDeg
+	}
+	deg := math.Pi / 180
+
+	// zone normal on an untilted body, split into the component along the
+	// body's facing (p), across it (s) and up (q)
+	zt := zoneTiltDeg * deg
+	rel := zoneAzimuthDeg * deg
+	p := math.Sin(zt) * math.Cos(rel)
+	s := math.Sin(zt) * math.Sin(rel)
+	q := math.Cos(zt)
+
+	// tip the body toward its facing
+	bt := bodyTiltDeg * deg
+	p, q = p*math.Cos(bt)+q*math.Sin(bt), -p*math.Sin(bt)+q*math.Cos(bt)
+
+	// back to east/north; "across" is the facing turned +90° in azimuth
+	fx, fy := azimuthDirection(bodyAzimuthDeg)
+	sx, sy := azimuthDirection(bodyAzimuthDeg + 90)
+	hx := p*fx + s*sx
+	hy := p*fy + s*sy
+
+	tilt := math.Acos(math.Max(-1, math.Min(1, q))) / deg
+	if math.Hypot(hx, hy) < 1e-12 {
+		return tilt, bodyAzimuthDeg
+	}
+	azimuth := math.Atan2(-hx, -hy) / deg
+	return tilt, azimuth
+}
+
+// windowZoneEnergy returns the Wh each array zone collects over window.
+func windowZoneEnergy(site solarSite, window chargeWindow) ([]zoneEnergy, error) {
+	zones := site.arrayZones()
+	out := make([]zoneEnergy, 0, len(zones))
+	for _, zone := range zones {
+		tilt, azimuth := zoneOrientation(window.TiltDeg, window.AzimuthDeg, zone.TiltDeg, zone.AzimuthDeg)
+		result := zoneEnergy{Name: zone.Name, TiltDeg: tilt, AzimuthDeg: azimuth}
+		if window.minutes() <= 0 {
+			out = append(out, result)
+			continue
+		}
+
+		weather, err := site.hourlyWeather(tilt, azimuth, window)
+		if err != nil {
+			return nil, err
+		}
+		if site.PV != nil {
+			result.EnergyWh = energyFromWeatherSeries(weather, site.PV, zone.Area, zone.CellEff, site.SystemEff, time.Hour, &window.Start, &window.End)
+		} else {
+			result.EnergyWh, _ = energyFromGTISeries(weather.Times, weather.GTI, zone.Area, zone.CellEff, site.SystemEff, time.Hour, 0, &window.Start, &window.End)
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+// weatherSourceError is a failure of the forecast or archive service, as
+// opposed to a request it could never answer.
+type weatherSourceError struct {
+	err error
+}
+
+func (e weatherSourceError) Error() string { return e.err.Error() }
+
+func (e weatherSourceError) Unwrap() error { return e.err }
+
+// hourlyWeather returns the hourly series covering window for one array
+// orientation, from the archive when configured and the forecast otherwise.
+// Failures of the weather services come back as weatherSourceError.
+func (site solarSite) hourlyWeather(tiltDeg, azimuthDeg float64, window chargeWindow) (HourlyWeather, error) {
+	if site.Archive != nil {
+		if err := site.Archive.checkOrientation(tiltDeg, azimuthDeg); err != nil {
+			return HourlyWeather{}, err
+		}
+		weather, err := fetchArchiveWeather(site, tiltDeg, azimuthDeg, window)
+		if err != nil && site.Archive.File == "" {
+			err = weatherSourceError{err}
+		}
+		return weather, err
+	}
+	if site.PV != nil {
+		weather, err := fetchHourlyWeather(site.Lat, site.Lon, tiltDeg, azimuthDeg, site.Timezone, site.ForecastDays)
+		if err != nil {
+			return HourlyWeather{}, weatherSourceError{err}
+		}
+		return weather, nil
+	}
+	times, gti, err := fetchHourlyGTI(site.Lat, site.Lon, tiltDeg, azimuthDeg, site.Timezone, site.ForecastDays)
+	if err != nil {
+		return HourlyWeather{}, weatherSourceError{err}
+	}
+	return HourlyWeather{Times: times, GTI: gti}, nil
+}
+
+func totalZoneEnergy(zones []zoneEnergy) float64 {
+	total := 0.0
+	for _, z := range zones {
+		total += z.EnergyWh
+	}
+	return total
+}
+
+// windowHourlyPower returns the whole array's charging power [W] at each
+// hourly sample of the weather source, summed across zones. The series is not
+// trimmed to window; callers interpolate inside it.
+func windowHourlyPower(site solarSite, window chargeWindow) ([]time.Time, []float64, error) {
+	var times []time.Time
+	var powerW []float64
+	for _, zone := range site.arrayZones() {
+		tilt, azimuth := zoneOrientation(window.TiltDeg, window.AzimuthDeg, zone.TiltDeg, zone.AzimuthDeg)
+		weather, err := site.hourlyWeather(tilt, azimuth, window)
+		if err != nil {
+			return nil, nil, err
+		}
+		if times == nil {
+			times = weather.Times
+			powerW = make([]float64, len(times))
+		}
+
+		n := min(len(times), len(weather.GTI))
+		for i := 0; i < n; i++ {
+			if site.PV != nil {
+				ambientC, windMPS := weather.conditionsAt(i)
+				powerW[i] += site.PV.powerW(weather.GTI[i], ambientC, windMPS, zone.Area, zone.CellEff, site.SystemEff)
+			} else {
+				powerW[i] += weather.GTI[i] * zone.Area * zone.CellEff * site.SystemEff
+			}
+		}
+	}
+	return times, powerW, nil
+}
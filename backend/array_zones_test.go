@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestZoneOrientationFlatZoneFollowsBody(t *testing.T) {
+	tilt, azimuth := zoneOrientation(45, -90, 0, 0)
+	if tilt != 45 || azimuth != -90 {
+		t.Fatalf("got tilt %.3f azimuth %.3f, want body orientation 45/-90", tilt, azimuth)
+	}
+}
+
+func TestZoneOrientationOnFlatBody(t *testing.T) {
+	// flat car facing south: a zone tilted 20° and turned +90° faces west
+	tilt, azimuth := zoneOrientation(0, 0, 20, 90)
+	if math.Abs(tilt-20) > 1e-9 || math.Abs(azimuth-90) > 1e-9 {
+		t.Fatalf("got tilt %.6f azimuth %.6f, want 20/90", tilt, azimuth)
+	}
+}
+
+func TestZoneOrientationStacksTiltAlongBodyFacing(t *testing.T) {
+	// a nose slope facing forward on a body tipped 30° toward the south
+	tilt, azimuth := zoneOrientation(30, 0, 15, 0)
+	if math.Abs(tilt-45) > 1e-9 || math.Abs(azimuth) > 1e-9 {
+		t.Fatalf("got tilt %.6f azimuth %.6f, want 45/0", tilt, azimuth)
+	}
+
+	// facing backwards, the slope cancels part of the body tilt
+	tilt, azimuth = zoneOrientation(30, 0, 15, 180)
+	if math.Abs(tilt-15) > 1e-9 || math.Abs(azimuth) > 1e-9 {
+		t.Fatalf("got tilt %.6f azimuth %.6f, want 15/0", tilt, azimuth)
+	}
+}
+
+func TestWindowZoneEnergySumsZones(t *testing.T) {
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	stubHourlyGTI(t, day, time.UTC, 1, 1000)
+
+	site := testSolarSite()
+	site.Zones = []arrayZone{
+		{Name: "top", Area: 3.0, CellEff: 0.24},
+		{Name: "nose", Area: 0.5, CellEff: 0.22, TiltDeg: 15},
+		{Name: "canopy", Area: 0.3, CellEff: 0.20, TiltDeg: 30, AzimuthDeg: 90},
+	}
+	window := defaultRaceDaySchedule(day, time.UTC).Race
+
+	zones, err := windowZoneEnergy(site, window)
+	if err != nil {
+		t.Fatalf("windowZoneEnergy returned error: %v", err)
+	}
+	if len(zones) != 3 {
+		t.Fatalf("got %d zones, want 3", len(zones))
+	}
+
+	hours := window.minutes() / 60
+	want := 1000 * (3.0*0.24 + 0.5*0.22 + 0.3*0.20) * site.SystemEff * hours
+	if got := totalZoneEnergy(zones); math.Abs(got-want) > 1e-6 {
+		t.Fatalf("got total %.6f Wh, want %.6f Wh", got, want)
+	}
+	if zones[1].Name != "nose" || math.Abs(zones[1].TiltDeg-20) > 1e-9 {
+		t.Fatalf("got nose zone %+v, want absolute tilt 20", zones[1])
+	}
+}
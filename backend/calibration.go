@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	calibrationPointStepM = 10.0 // spacing of the returned comparison points
+	calibrationFitPasses  = 4    // coordinate-descent sweeps over the fitted fields
+)
+
+// calibrationFitBounds are the fields calibrateTelemetry can fit and the
+// range each is searched over.
+var calibrationFitBounds = map[string][2]float64{
+	"etaDrive":             {0.3, 1},
+	"additionalEfficiency": {-50, 100},
+	"cRr":                  {0, 0.05},
+}
+
+// calibrationPoint compares the log and the model at one distance into the
+// lap. Elapsed time and energy are cumulative from the start of the lap.
+type calibrationPoint struct {
+	DistanceM      float64 `json:"distanceM"`
+	LoggedSpeed    float64 `json:"loggedSpeed"`
+	SimSpeed       float64 `json:"simSpeed"`
+	LoggedElapsedS float64 `json:"loggedElapsedS"`
+	SimElapsedS    float64 `json:"simElapsedS"`
+	LoggedEnergyWh float64 `json:"loggedEnergyWh"`
+	SimEnergyWh    float64 `json:"simEnergyWh"`
+}
+
+// calibrationResiduals summarise model minus log over one lap.
+type calibrationResiduals struct {
+	SpeedRMSE        float64 `json:"speedRmse"` // m/s
+	SpeedBias        float64 `json:"speedBias"` // mean of sim - logged
+	LoggedLapTimeS   float64 `json:"loggedLapTimeS"`
+	SimLapTimeS      float64 `json:"simLapTimeS"`
+	LapTimeResidualS float64 `json:"lapTimeResidualS"`
+	LoggedEnergyWh   float64 `json:"loggedEnergyWh"`
+	SimEnergyWh      float64 `json:"simEnergyWh"`
+	EnergyResidualWh float64 `json:"energyResidualWh"`
+	EnergyRMSEWh     float64 `json:"energyRmseWh"` // of the cumulative energy curve
+	Cost             float64 `json:"cost"`         // sum of the squared relative speed, energy and lap-time errors
+}
+
+// telemetryCalibrationResult compares a recorded lap with the model before
+// and, when fields were fitted, after calibration.
+type telemetryCalibrationResult struct {
+	V             float64               `json:"v"` // cruise target used for the model lap
+	LoggedLengthM float64               `json:"loggedLengthM"`
+	SimLengthM    float64               `json:"simLengthM"`
+	DistanceScale float64               `json:"distanceScale"` // logged distances are scaled by this to the model lap
+	Before        calibrationResiduals  `json:"before"`
+	After         *calibrationResiduals `json:"after,omitempty"`
+	Fitted        map[string]float64    `json:"fitted,omitempty"`
+	Points        []calibrationPoint    `json:"points"` // for the calibrated inputs when fitted
+	Notes         []string              `json:"notes,omitempty"`
+	PresetUpdate  simulationInputs      `json:"presetUpdate"`
+}
+
+// alignedLog is a recorded lap on the model's distance axis.
+type alignedLog struct {
+	distance, elapsed, speed, energy []float64
+}
+
+// interpolateAt linearly interpolates ys at x over increasing xs, holding
+// the end values outside them.
+func interpolateAt(xs, ys []float64, x float64) float64 {
+	i := sort.SearchFloat64s(xs, x)
+	if i == 0 {
+		return ys[0]
+	}
+	if i >= len(xs) {
+		return ys[len(ys)-1]
+	}
+	span := xs[i] - xs[i-1]
+	if span <= 0 {
+		return ys[i]
+	}
+	return ys[i-1] + (x-xs[i-1])/span*(ys[i]-ys[i-1])
+}
+
+// compareWithLog lines the model lap up with the log by distance and
+// returns the residuals and comparison points.
+func compareWithLog(points []telemetryPoint, logged alignedLog) (calibrationResiduals, []calibrationPoint) {
+	var res calibrationResiduals
+	var out []calibrationPoint
+	n := len(logged.distance) - 1
+	res.LoggedLapTimeS = logged.elapsed[n]
+	res.LoggedEnergyWh = logged.energy[n]
+	first, last := points[0], points[len(points)-1]
+	res.SimLapTimeS = last.ElapsedS - first.ElapsedS
+	res.SimEnergyWh = last.EnergyUsedWh - first.EnergyUsedWh
+	res.LapTimeResidualS = res.SimLapTimeS - res.LoggedLapTimeS
+	res.EnergyResidualWh = res.SimEnergyWh - res.LoggedEnergyWh
+
+	speedSq, energySq, bias, meanSpeed := 0.0, 0.0, 0.0, 0.0
+	nextPoint := 0.0
+	for i, p := range points {
+		d := p.Distance - first.Distance
+		logSpeed := interpolateAt(logged.distance, logged.speed, d)
+		logEnergy := interpolateAt(logged.distance, logged.energy, d)
+		simEnergy := p.EnergyUsedWh - first.EnergyUsedWh
+		speedSq += (p.Speed - logSpeed) * (p.Speed - logSpeed)
+		energySq += (simEnergy - logEnergy) * (simEnergy - logEnergy)
+		bias += p.Speed - logSpeed
+		meanSpeed += logSpeed
+		if d >= nextPoint || i == len(points)-1 {
+			out = append(out, calibrationPoint{
+				DistanceM:      d,
+				LoggedSpeed:    logSpeed,
+				SimSpeed:       p.Speed,
+				LoggedElapsedS: interpolateAt(logged.distance, logged.elapsed, d),
+				SimElapsedS:    p.ElapsedS - first.ElapsedS,
+				LoggedEnergyWh: logEnergy,
+				SimEnergyWh:    simEnergy,
+			})
+			nextPoint += calibrationPointStepM
+		}
+	}
+	count := float64(len(points))
+	res.SpeedRMSE = math.Sqrt(speedSq / count)
+	res.EnergyRMSEWh = math.Sqrt(energySq / count)
+	res.SpeedBias = bias / count
+	meanSpeed /= count
+
+	if meanSpeed > 0 {
+		res.Cost += (res.SpeedRMSE / meanSpeed) * (res.SpeedRMSE / meanSpeed)
+	}
+	if res.LoggedEnergyWh > 0 {
+		res.Cost += (res.EnergyRMSEWh / res.LoggedEnergyWh) * (res.EnergyRMSEWh / res.LoggedEnergyWh)
+	}
+	if res.LoggedLapTimeS > 0 {
+		res.Cost += (res.LapTimeResidualS / res.LoggedLapTimeS) * (res.LapTimeResidualS / res.LoggedLapTimeS)
+	}
+	return res, out
+}
+
+// calibrateTelemetry compares one recorded lap with buildTelemetryForInputs
+// on the same track and, for the fields listed in fit, searches for the
+// values that minimise the residual cost. Fields are fitted one at a time by
+// coordinate descent; etaDrive, additionalEfficiency and cRr all scale the
+// energy, so fitting several at once only separates them when the log has
+// enough speed variation.
+//
+// The log's distance (from DistanceM, GPS or integrated speed) is scaled to
+// the model lap length before alignment. v sets the model's cruise target;
+// 0 uses the 90th percentile of the logged speed.
+func calibrateTelemetry(inputs simulationInputs, segments []trackSegment, samples []raceLogSample, v float64, fit []string) (telemetryCalibrationResult, error) {
+	if err := validateRaceLog(samples); err != nil {
+		return telemetryCalibrationResult{}, err
+	}
+	for _, field := range fit {
+		if _, ok := calibrationFitBounds[field]; !ok {
+			return telemetryCalibrationResult{}, fmt.Errorf("cannot fit %q (want etaDrive, additionalEfficiency or cRr)", field)
+		}
+	}
+	if v <= 0 {
+		speeds := make([]float64, len(samples))
+		for i, s := range samples {
+			speeds[i] = s.Speed
+		}
+		sort.Float64s(speeds)
+		v = percentile(speeds, 90)
+	}
+	if v <= 0 {
+		return telemetryCalibrationResult{}, fmt.Errorf("telemetry log has no positive speed")
+	}
+	inputs.V = v
+
+	simulate := func(inputs simulationInputs) ([]telemetryPoint, error) {
+		points, err := buildTelemetryForInputs(segments, true, inputs)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) < 2 {
+			return nil, fmt.Errorf("telemetry lap produced no points")
+		}
+		return points, nil
+	}
+	points, err := simulate(inputs)
+	if err != nil {
+		return telemetryCalibrationResult{}, err
+	}
+
+	distances := logDistances(samples)
+	result := telemetryCalibrationResult{
+		V:             v,
+		LoggedLengthM: distances[len(distances)-1] - distances[0],
+		SimLengthM:    points[len(points)-1].Distance - points[0].Distance,
+	}
+	if result.LoggedLengthM <= 0 {
+		return telemetryCalibrationResult{}, fmt.Errorf("telemetry log covers no distance")
+	}
+	result.DistanceScale = result.SimLengthM / result.LoggedLengthM
+	if math.Abs(result.DistanceScale-1) > 0.05 {
+		result.Notes = append(result.Notes, fmt.Sprintf("logged lap is %.0f m against a %.0f m model lap; check the log is one lap of this track", result.LoggedLengthM, result.SimLengthM))
+	}
+
+	// the pack only sees the drive draw less the array's output
+	solarW := inputs.SolarWhPerMin * 60
+	energy := logEnergyWh(samples, solarW)
+	if solarW > 0 && !hasArrayPower(samples) {
+		result.Notes = append(result.Notes, fmt.Sprintf("log has no array power; the modelled %.0f W of solar is added back to the pack draw", solarW))
+	}
+	logged := alignedLog{
+		distance: make([]float64, len(samples)),
+		elapsed:  make([]float64, len(samples)),
+		speed:    make([]float64, len(samples)),
+		energy:   energy,
+	}
+	for i, s := range samples {
+		logged.distance[i] = (distances[i] - distances[0]) * result.DistanceScale
+		logged.elapsed[i] = s.T - samples[0].T
+		logged.speed[i] = s.Speed
+	}
+	if energy[len(energy)-1] <= 0 {
+		result.Notes = append(result.Notes, "telemetry log has no battery draw; energy is not compared")
+	}
+
+	result.Before, result.Points = compareWithLog(points, logged)
+	result.PresetUpdate = inputs
+	result.PresetUpdate.V = 0
+	if len(fit) == 0 {
+		return result, nil
+	}
+
+	cost := func(inputs simulationInputs) (float64, bool) {
+		if validateSimulationInputs(inputs) != nil {
+			return 0, false
+		}
+		points, err := simulate(inputs)
+		if err != nil {
+			return 0, false
+		}
+		res, _ := compareWithLog(points, logged)
+		return -res.Cost, true
+	}
+	best := inputs
+	bestCost := result.Before.Cost
+	for pass := 0; pass < calibrationFitPasses; pass++ {
+		start := bestCost
+		for _, field := range fit {
+			bounds := calibrationFitBounds[field]
+			search, err := maximizeUnimodal(func(x float64) (float64, bool) {
+				trial := best
+				_ = setInputField(&trial, field, x)
+				return cost(trial)
+			}, optimizeOptions{Lower: bounds[0], Upper: bounds[1], Step: (bounds[1] - bounds[0]) / 20, Tol: (bounds[1] - bounds[0]) * 1e-4, MaxIter: 80})
+			if err != nil || -search.Value >= bestCost {
+				continue
+			}
+			_ = setInputField(&best, field, search.X)
+			bestCost = -search.Value
+		}
+		if start-bestCost <= 1e-9*start {
+			break
+		}
+	}
+
+	points, err = simulate(best)
+	if err != nil {
+		return telemetryCalibrationResult{}, err
+	}
+	after, afterPoints := compareWithLog(points, logged)
+	result.After, result.Points = &after, afterPoints
+	result.Fitted = map[string]float64{}
+	for _, field := range fit {
+		result.Fitted[field], _ = inputField(best, field)
+	}
+	result.PresetUpdate = best
+	result.PresetUpdate.V = 0
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// loggedLapFromModel turns a model lap into a telemetry log sampled every
+// stride points, as a pack at 100 V would record it with the array charging
+// at inputs.SolarWhPerMin.
+func loggedLapFromModel(t *testing.T, inputs simulationInputs, stride int) []raceLogSample {
+	t.Helper()
+	points, err := buildTelemetryForInputs(defaultTrackSegments(), true, inputs)
+	if err != nil {
+		t.Fatalf("buildTelemetryForInputs returned error: %v", err)
+	}
+	var samples []raceLogSample
+	for i := 0; i < len(points); i += stride {
+		p := points[i]
+		samples = append(samples, raceLogSample{T: p.ElapsedS, DistanceM: p.Distance, Speed: p.Speed, CurrentA: (p.BatteryPowerW - inputs.SolarWhPerMin*60) / 100, VoltageV: 100})
+	}
+	return samples
+}
+
+func TestCalibrateTelemetryMatchingLogHasSmallResiduals(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = 20
+	samples := loggedLapFromModel(t, inputs, 1)
+
+	got, err := calibrateTelemetry(defaultSimulationInputs(), defaultTrackSegments(), samples, 20, nil)
+	if err != nil {
+		t.Fatalf("calibrateTelemetry returned error: %v", err)
+	}
+	if got.Before.SpeedRMSE > 1e-6 || math.Abs(got.Before.LapTimeResidualS) > 1e-6 {
+		t.Fatalf("got speed RMSE %.6f lap time residual %.6f, want 0", got.Before.SpeedRMSE, got.Before.LapTimeResidualS)
+	}
+	if math.Abs(got.Before.EnergyResidualWh) > 0.01*got.Before.LoggedEnergyWh {
+		t.Fatalf("got energy residual %.3f Wh of %.3f Wh, want within 1%%", got.Before.EnergyResidualWh, got.Before.LoggedEnergyWh)
+	}
+	if got.After != nil || got.Fitted != nil {
+		t.Fatalf("got a fit without fields to fit: %+v", got.Fitted)
+	}
+}
+
+func TestCalibrateTelemetryFitsEtaDrive(t *testing.T) {
+	truth := defaultSimulationInputs()
+	truth.V, truth.EtaDrive = 20, 0.8
+	samples := loggedLapFromModel(t, truth, 5)
+
+	got, err := calibrateTelemetry(defaultSimulationInputs(), defaultTrackSegments(), samples, 20, []string{"etaDrive"})
+	if err != nil {
+		t.Fatalf("calibrateTelemetry returned error: %v", err)
+	}
+	if got.After == nil || got.After.Cost >= got.Before.Cost {
+		t.Fatalf("got cost %+v after %.6f before, want an improvement", got.After, got.Before.Cost)
+	}
+	if math.Abs(got.Fitted["etaDrive"]-0.8) > 0.01 || got.PresetUpdate.EtaDrive != got.Fitted["etaDrive"] {
+		t.Fatalf("got etaDrive %.4f (preset %.4f), want 0.8", got.Fitted["etaDrive"], got.PresetUpdate.EtaDrive)
+	}
+}
+
+func TestCalibrateTelemetryUsesLoggedArrayPower(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = 20
+	samples := loggedLapFromModel(t, inputs, 1)
+	for i := range samples {
+		samples[i].ArrayPowerW = inputs.SolarWhPerMin * 60
+	}
+
+	// the logged array power stands in for the modelled solar
+	calib := defaultSimulationInputs()
+	calib.SolarWhPerMin = 0
+	got, err := calibrateTelemetry(calib, defaultTrackSegments(), samples, 20, nil)
+	if err != nil {
+		t.Fatalf("calibrateTelemetry returned error: %v", err)
+	}
+	if math.Abs(got.Before.EnergyResidualWh) > 0.01*got.Before.LoggedEnergyWh {
+		t.Fatalf("got energy residual %.3f Wh of %.3f Wh, want within 1%%", got.Before.EnergyResidualWh, got.Before.LoggedEnergyWh)
+	}
+}
+
+func TestCalibrateTelemetryRejectsUnknownFitField(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = 20
+	_, err := calibrateTelemetry(inputs, defaultTrackSegments(), loggedLapFromModel(t, inputs, 10), 0, []string{"batteryWh"})
+	if err == nil {
+		t.Fatalf("got no error fitting batteryWh")
+	}
+}
+
+func TestDecodeRaceLogCSVReadsNamedColumns(t *testing.T) {
+	csv := "speed_mps,time_s,voltage_v,current_a,lat,lon\n10,0,100,20,36.0,-86.0\n11,1,100,22,36.0001,-86.0\n"
+	samples, err := decodeRaceLogCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("decodeRaceLogCSV returned error: %v", err)
+	}
+	if len(samples) != 2 || samples[1].T != 1 || samples[1].Speed != 11 || samples[1].batteryPowerW() != 2200 {
+		t.Fatalf("got %+v, want two samples with named columns", samples)
+	}
+	d := logDistances(samples)
+	if math.Abs(d[1]-11.12) > 0.05 {
+		t.Fatalf("got GPS distance %.3f m, want about 11.12 m", d[1])
+	}
+
+	if _, err := decodeRaceLogCSV(strings.NewReader("time_s,speed_mps\n0,10\n")); err == nil {
+		t.Fatalf("got no error for a log without battery columns")
+	}
+}
+
+func TestTelemetryCalibrationHandlerReturnsResiduals(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = 20
+	body, err := json.Marshal(telemetryCalibrationRequest{Inputs: defaultSimulationInputs(), Samples: loggedLapFromModel(t, inputs, 10), V: 20})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/calibrate/telemetry", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	telemetryCalibrationHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got telemetryCalibrationResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || len(got.Points) == 0 || got.Before.LoggedLapTimeS <= 0 {
+		t.Fatalf("got ok=%v with %d points and lap time %.1f, want residuals", got.OK, len(got.Points), got.Before.LoggedLapTimeS)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCANPeriodS = 0.1
+	minCANPeriodS     = 0.001
+	maxCANSteps       = 500000     // about 14 hours at the default period
+	maxCANCells       = 5000000    // timeline steps × (decoded signals + the sample row)
+	canExtendedFlag   = 0x80000000 // DBC marks extended (29-bit) IDs with bit 31
+	canIDMask         = 0x1FFFFFFF
+)
+
+// dbcSignal is one SG_ line of a DBC file.
+type dbcSignal struct {
+	Name         string  `json:"name"`
+	StartBit     int     `json:"startBit"`
+	Length       int     `json:"length"`
+	LittleEndian bool    `json:"littleEndian"` // @1 (Intel); @0 is Motorola
+	Signed       bool    `json:"signed"`
+	Scale        float64 `json:"scale"`
+	Offset       float64 `json:"offset"`
+	Unit         string  `json:"unit,omitempty"`
+}
+
+// dbcMessage is one BO_ block of a DBC file.
+type dbcMessage struct {
+	ID      uint32      `json:"id"`
+	Name    string      `json:"name"`
+	DLC     int         `json:"dlc"`
+	Signals []dbcSignal `json:"signals"`
+}
+
+// dbcDatabase holds the messages of a DBC file by CAN ID.
+type dbcDatabase struct {
+	Messages map[uint32]*dbcMessage
+}
+
+// parseDBC reads the BO_ and SG_ lines of a DBC file and ignores everything
+// else (nodes, comments, value tables). Multiplexed signals are skipped.
+func parseDBC(r io.Reader) (dbcDatabase, error) {
+	db := dbcDatabase{Messages: map[uint32]*dbcMessage{}}
+	var current *dbcMessage
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "BO_ "):
+			// BO_ 1024 BMS_Pack: 8 BMS
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return dbcDatabase{}, fmt.Errorf("DBC line %d: malformed BO_", lineNo)
+			}
+			id, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return dbcDatabase{}, fmt.Errorf("DBC line %d: %w", lineNo, err)
+			}
+			dlc, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return dbcDatabase{}, fmt.Errorf("DBC line %d: %w", lineNo, err)
+			}
+			current = &dbcMessage{ID: uint32(id) &^ canExtendedFlag, Name: strings.TrimSuffix(fields[2], ":"), DLC: dlc}
+			db.Messages[current.ID] = current
+		case strings.HasPrefix(line, "SG_ "):
+			if current == nil {
+				return dbcDatabase{}, fmt.Errorf("DBC line %d: SG_ outside a message", lineNo)
+			}
+			sig, ok, err := parseDBCSignal(line)
+			if err != nil {
+				return dbcDatabase{}, fmt.Errorf("DBC line %d: %w", lineNo, err)
+			}
+			if ok {
+				current.Signals = append(current.Signals, sig)
+			}
+		case line == "":
+			current = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return dbcDatabase{}, err
+	}
+	if len(db.Messages) == 0 {
+		return dbcDatabase{}, fmt.Errorf("DBC has no messages")
+	}
+	return db, nil
+}
+
+// parseDBCSignal parses
+//
+//	SG_ PackVoltage : 0|16@1+ (0.01,0) [0|655.35] "V" Vector__XXX
+//
+// and reports false for multiplexed signals.
+func parseDBCSignal(line string) (dbcSignal, bool, error) {
+	head, layout, ok := strings.Cut(strings.TrimPrefix(line, "SG_ "), ":")
+	if !ok {
+		return dbcSignal{}, false, fmt.Errorf("malformed SG_")
+	}
+	names := strings.Fields(head)
+	if len(names) == 0 {
+		return dbcSignal{}, false, fmt.Errorf("SG_ has no name")
+	}
+	if len(names) > 1 {
+		return dbcSignal{}, false, nil // M / mN multiplexing
+	}
+	sig := dbcSignal{Name: names[0]}
+
+	fields := strings.Fields(layout)
+	if len(fields) < 2 {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: malformed layout", sig.Name)
+	}
+	// 0|16@1+
+	bits, format, ok := strings.Cut(fields[0], "@")
+	start, length, ok2 := strings.Cut(bits, "|")
+	if !ok || !ok2 || len(format) != 2 {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: malformed bit layout %q", sig.Name, fields[0])
+	}
+	var err error
+	if sig.StartBit, err = strconv.Atoi(start); err != nil {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: %w", sig.Name, err)
+	}
+	if sig.Length, err = strconv.Atoi(length); err != nil {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: %w", sig.Name, err)
+	}
+	if sig.Length < 1 || sig.Length > 64 {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: length must be 1..64 bits", sig.Name)
+	}
+	sig.LittleEndian = format[0] == '1'
+	sig.Signed = format[1] == '-'
+
+	// (0.01,0)
+	factors := strings.Trim(fields[1], "()")
+	scale, offset, ok := strings.Cut(factors, ",")
+	if !ok {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: malformed factor %q", sig.Name, fields[1])
+	}
+	if sig.Scale, err = strconv.ParseFloat(scale, 64); err != nil {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: %w", sig.Name, err)
+	}
+	if sig.Offset, err = strconv.ParseFloat(offset, 64); err != nil {
+		return dbcSignal{}, false, fmt.Errorf("signal %s: %w", sig.Name, err)
+	}
+	if _, rest, ok := strings.Cut(layout, `"`); ok {
+		sig.Unit, _, _ = strings.Cut(rest, `"`)
+	}
+	return sig, true, nil
+}
+
+// decode extracts the signal's physical value from a frame payload, and
+// false when the payload is too short for it.
+func (s dbcSignal) decode(data []byte) (float64, bool) {
+	var raw uint64
+	pos := s.StartBit
+	for i := 0; i < s.Length; i++ {
+		if pos < 0 || pos/8 >= len(data) {
+			return 0, false
+		}
+		bit := uint64(data[pos/8]>>(pos%8)) & 1
+		if s.LittleEndian {
+			raw |= bit << i
+			pos++
+		} else {
+			// Motorola: MSB first, walking down each byte then into the next
+			raw = raw<<1 | bit
+			if pos%8 == 0 {
+				pos += 15
+			} else {
+				pos--
+			}
+		}
+	}
+	value := float64(raw)
+	if s.Signed && s.Length < 64 && raw&(1<<(s.Length-1)) != 0 {
+		value = float64(int64(raw) - int64(1)<<s.Length)
+	} else if s.Signed {
+		value = float64(int64(raw))
+	}
+	return value*s.Scale + s.Offset, true
+}
+
+// canFrame is one logged CAN frame.
+type canFrame struct {
+	T    float64
+	ID   uint32
+	Data []byte
+}
+
+// parseCANID reads a hex CAN ID with or without 0x.
+func parseCANID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CAN ID %q", s)
+	}
+	return uint32(id) & canIDMask, nil
+}
+
+// decodeCandump reads candump text in either the log-file form
+//
+//	(1718000000.123456) can0 400#E803D007
+//
+// or the console form with a timestamp (candump -t a)
+//
+//	(1718000000.123456)  can0  400   [4]  E8 03 D0 07
+//
+// Remote frames are skipped.
+func decodeCandump(r io.Reader) ([]canFrame, error) {
+	var frames []canFrame
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "(") {
+			return nil, fmt.Errorf("candump line %d: want a (timestamp) interface frame", lineNo)
+		}
+		t, err := strconv.ParseFloat(strings.Trim(fields[0], "()"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("candump line %d: %w", lineNo, err)
+		}
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("candump line %d: timestamp must be finite", lineNo)
+		}
+
+		var idText, dataText string
+		if id, data, ok := strings.Cut(fields[2], "#"); ok {
+			idText, dataText = id, data
+			if strings.HasPrefix(dataText, "#") { // CAN FD: ID##<flags><data>
+				dataText = dataText[min(2, len(dataText)):]
+			}
+			if strings.HasPrefix(dataText, "R") {
+				continue
+			}
+		} else {
+			if len(fields) < 4 || !strings.HasPrefix(fields[3], "[") {
+				return nil, fmt.Errorf("candump line %d: want ID#DATA or ID [len] bytes", lineNo)
+			}
+			if len(fields) > 4 && fields[4] == "remote" {
+				continue
+			}
+			idText, dataText = fields[2], strings.Join(fields[4:], "")
+		}
+		id, err := parseCANID(idText)
+		if err != nil {
+			return nil, fmt.Errorf("candump line %d: %w", lineNo, err)
+		}
+		data, err := hex.DecodeString(dataText)
+		if err != nil {
+			return nil, fmt.Errorf("candump line %d: %w", lineNo, err)
+		}
+		frames = append(frames, canFrame{T: t, ID: id, Data: data})
+	}
+	return frames, scanner.Err()
+}
+
+// decodeCANCSV reads frames from a CSV with the header time_s,id,data; id is
+// hex and data is hex bytes, optionally space separated.
+func decodeCANCSV(r io.Reader) ([]canFrame, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("CAN CSV has no data rows")
+	}
+	index := map[string]int{}
+	for i, name := range rows[0] {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"time_s", "id", "data"} {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("CAN CSV is missing column %q", name)
+		}
+	}
+
+	frames := make([]canFrame, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		if len(row) < len(rows[0]) {
+			return nil, fmt.Errorf("CAN CSV row %d: want %d columns, got %d", i+2, len(rows[0]), len(row))
+		}
+		t, err := strconv.ParseFloat(row[index["time_s"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("CAN CSV row %d: %w", i+2, err)
+		}
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("CAN CSV row %d: time_s must be finite", i+2)
+		}
+		id, err := parseCANID(row[index["id"]])
+		if err != nil {
+			return nil, fmt.Errorf("CAN CSV row %d: %w", i+2, err)
+		}
+		data, err := hex.DecodeString(strings.ReplaceAll(row[index["data"]], " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("CAN CSV row %d: %w", i+2, err)
+		}
+		frames = append(frames, canFrame{T: t, ID: id, Data: data})
+	}
+	return frames, nil
+}
+
+// decodeCANLog reads frames in format "candump" or "csv"; an empty format
+// picks candump when the first non-blank character is '('.
+func decodeCANLog(text, format string) ([]canFrame, error) {
+	if format == "" {
+		format = "csv"
+		if strings.HasPrefix(strings.TrimSpace(text), "(") {
+			format = "candump"
+		}
+	}
+	switch format {
+	case "candump":
+		return decodeCandump(strings.NewReader(text))
+	case "csv":
+		return decodeCANCSV(strings.NewReader(text))
+	default:
+		return nil, fmt.Errorf("unknown CAN log format %q (want candump or csv)", format)
+	}
+}
+
+// canChannelMap names the decoded signals ("Signal" or "Message.Signal")
+// that feed the simulator channels. Empty entries are guessed from the
+// signal names: volt, current, soc, rpm and temp.
+type canChannelMap struct {
+	VoltageV     string   `json:"voltageV,omitempty"`
+	CurrentA     string   `json:"currentA,omitempty"`
+	SOC          string   `json:"soc,omitempty"`
+	MotorRPM     string   `json:"motorRpm,omitempty"`
+	Temperatures []string `json:"temperatures,omitempty"`
+}
+
+// canSample is one step of the common timeline in the simulator's terms:
+// the embedded liveSample can go straight to /calibrate/telemetry or a live
+// session. Speed comes from motor RPM through the wheel radius and
+// DistanceM integrates it. Temperatures are in Signals, under the names in
+// Channels.Temperatures.
+type canSample struct {
+	liveSample
+	MotorRPM float64 `json:"motorRpm"`
+}
+
+// canDecodeResult is a CAN log decoded onto a common timeline.
+type canDecodeResult struct {
+	StartT   float64              `json:"startT"` // log time of the first frame; sample times count from here
+	PeriodS  float64              `json:"periodS"`
+	Frames   int                  `json:"frames"`
+	Decoded  int                  `json:"decoded"` // frames with a DBC message
+	Unknown  []string             `json:"unknownIds,omitempty"`
+	Channels canChannelMap        `json:"channels"` // as resolved
+	Signals  map[string][]float64 `json:"signals"`  // every decoded signal on the timeline, by Message.Signal
+	Units    map[string]string    `json:"units,omitempty"`
+	Samples  []canSample          `json:"samples"`
+}
+
+// decodeCANTimeline decodes frames with db and resamples every signal onto
+// a common periodS timeline from the first frame to the last, holding the
+// latest value (and the first value before a signal's first frame). Motor
+// RPM becomes speed as rpm / gearRatio * 2π/60 * rWheel.
+func decodeCANTimeline(db dbcDatabase, frames []canFrame, channels canChannelMap, periodS, rWheel, gearRatio float64) (canDecodeResult, error) {
+	if len(frames) == 0 {
+		return canDecodeResult{}, fmt.Errorf("CAN log has no frames")
+	}
+	if periodS <= 0 {
+		periodS = defaultCANPeriodS
+	}
+	if periodS < minCANPeriodS {
+		return canDecodeResult{}, fmt.Errorf("periodS must be at least %g s", minCANPeriodS)
+	}
+	if gearRatio <= 0 {
+		gearRatio = 1
+	}
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].T < frames[j].T })
+	// checked before decoding so a stray timestamp cannot size the timeline
+	if span := frames[len(frames)-1].T - frames[0].T; span/periodS >= maxCANSteps {
+		return canDecodeResult{}, fmt.Errorf("CAN log spans %.0f s, more than %d steps of %g s; check the timestamps or use a longer periodS", span, maxCANSteps, periodS)
+	}
+
+	type point struct{ t, v float64 }
+	series := map[string][]point{}
+	units := map[string]string{}
+	unknown := map[uint32]bool{}
+	result := canDecodeResult{PeriodS: periodS, Frames: len(frames)}
+	for _, f := range frames {
+		msg, ok := db.Messages[f.ID]
+		if !ok {
+			unknown[f.ID] = true
+			continue
+		}
+		result.Decoded++
+		for _, sig := range msg.Signals {
+			if v, ok := sig.decode(f.Data); ok {
+				key := msg.Name + "." + sig.Name
+				series[key] = append(series[key], point{f.T, v})
+				units[key] = sig.Unit
+			}
+		}
+	}
+	for id := range unknown {
+		result.Unknown = append(result.Unknown, fmt.Sprintf("0x%X", id))
+	}
+	sort.Strings(result.Unknown)
+	if len(series) == 0 {
+		return canDecodeResult{}, fmt.Errorf("no frames in the log match the DBC")
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resolve := func(want, guess string) (string, error) {
+		if want == "" {
+			for _, name := range names {
+				if strings.Contains(strings.ToLower(name[strings.Index(name, ".")+1:]), guess) {
+					return name, nil
+				}
+			}
+			return "", nil
+		}
+		var found []string
+		for _, name := range names {
+			if name == want || strings.HasSuffix(name, "."+want) {
+				found = append(found, name)
+			}
+		}
+		if len(found) != 1 {
+			return "", fmt.Errorf("signal %q matches %d decoded signals, want 1", want, len(found))
+		}
+		return found[0], nil
+	}
+	var err error
+	resolved := canChannelMap{}
+	for _, ch := range []struct {
+		dst        *string
+		want, hint string
+	}{
+		{&resolved.VoltageV, channels.VoltageV, "volt"},
+		{&resolved.CurrentA, channels.CurrentA, "current"},
+		{&resolved.SOC, channels.SOC, "soc"},
+		{&resolved.MotorRPM, channels.MotorRPM, "rpm"},
+	} {
+		if *ch.dst, err = resolve(ch.want, ch.hint); err != nil {
+			return canDecodeResult{}, err
+		}
+	}
+	if len(channels.Temperatures) == 0 {
+		for _, name := range names {
+			if strings.Contains(strings.ToLower(name[strings.Index(name, ".")+1:]), "temp") {
+				resolved.Temperatures = append(resolved.Temperatures, name)
+			}
+		}
+	}
+	for _, want := range channels.Temperatures {
+		name, err := resolve(want, "")
+		if err != nil {
+			return canDecodeResult{}, err
+		}
+		resolved.Temperatures = append(resolved.Temperatures, name)
+	}
+	result.Channels = resolved
+
+	t0, t1 := frames[0].T, frames[len(frames)-1].T
+	result.StartT = t0
+	steps := int(math.Floor((t1-t0)/periodS+1e-9)) + 1
+	if steps > maxCANCells/(len(series)+1) {
+		return canDecodeResult{}, fmt.Errorf("CAN timeline would hold %d steps of %d signals, more than the %d values allowed; use a longer periodS or a smaller DBC", steps, len(series), maxCANCells)
+	}
+	result.Signals = make(map[string][]float64, len(series))
+	result.Units = units
+	for _, name := range names {
+		points := series[name]
+		values := make([]float64, steps)
+		j := 0
+		for k := range values {
+			t := t0 + float64(k)*periodS
+			for j+1 < len(points) && points[j+1].t <= t+1e-9 {
+				j++
+			}
+			values[k] = points[j].v
+		}
+		result.Signals[name] = values
+	}
+
+	channel := func(name string, k int) float64 {
+		if name == "" {
+			return 0
+		}
+		return result.Signals[name][k]
+	}
+	result.Samples = make([]canSample, steps)
+	for k := range result.Samples {
+		s := canSample{MotorRPM: channel(resolved.MotorRPM, k)}
+		s.T = float64(k) * periodS
+		s.VoltageV = channel(resolved.VoltageV, k)
+		s.CurrentA = channel(resolved.CurrentA, k)
+		if resolved.SOC != "" {
+			soc := channel(resolved.SOC, k)
+			s.SOC = &soc
+		}
+		s.Speed = math.Abs(s.MotorRPM) / gearRatio * 2 * math.Pi / 60 * rWheel
+		distance := 0.0
+		if k > 0 {
+			prev := result.Samples[k-1]
+			distance = *prev.DistanceM + 0.5*(prev.Speed+s.Speed)*periodS
+		}
+		s.DistanceM = &distance
+		result.Samples[k] = s
+	}
+	return result, nil
+}
+
+// writeCANTimelineCSV writes the timeline with the column names
+// decodeRaceLogCSV reads, followed by every decoded signal.
+func writeCANTimelineCSV(w io.Writer, result canDecodeResult) error {
+	names := make([]string, 0, len(result.Signals))
+	for name := range result.Signals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := csv.NewWriter(w)
+	header := append([]string{"time_s", "distance_m", "speed_mps", "current_a", "voltage_v", "soc", "motor_rpm"}, names...)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for k, s := range result.Samples {
+		soc := ""
+		if s.SOC != nil {
+			soc = format(*s.SOC)
+		}
+		row := []string{format(s.T), format(*s.DistanceM), format(s.Speed), format(s.CurrentA), format(s.VoltageV), soc, format(s.MotorRPM)}
+		for _, name := range names {
+			row = append(row, format(result.Signals[name][k]))
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDBC = `VERSION ""
+
+BU_: BMS MC
+
+BO_ 1024 BMS_Pack: 8 BMS
+ SG_ PackVoltage : 0|16@1+ (0.01,0) [0|655.35] "V" Vector__XXX
+ SG_ PackCurrent : 16|16@1- (0.1,0) [-3276.8|3276.7] "A" Vector__XXX
+ SG_ SOC : 32|8@1+ (0.5,0) [0|100] "%" Vector__XXX
+ SG_ CellTemp : 40|8@1+ (1,-40) [-40|215] "degC" Vector__XXX
+
+BO_ 2147484160 MC_Status: 8 MC
+ SG_ MotorRPM : 7|16@0- (1,0) [-32768|32767] "rpm" Vector__XXX
+ SG_ MotorTemp : 23|8@0+ (1,-40) [-40|215] "degC" Vector__XXX
+
+CM_ SG_ 1024 SOC "state of charge";
+`
+
+func TestParseDBCReadsMessagesAndSignals(t *testing.T) {
+	db, err := parseDBC(strings.NewReader(testDBC))
+	if err != nil {
+		t.Fatalf("parseDBC returned error: %v", err)
+	}
+	pack, ok := db.Messages[1024]
+	if !ok || pack.Name != "BMS_Pack" || len(pack.Signals) != 4 {
+		t.Fatalf("got %+v, want BMS_Pack with 4 signals", pack)
+	}
+	if sig := pack.Signals[1]; sig.Name != "PackCurrent" || !sig.LittleEndian || !sig.Signed || sig.Scale != 0.1 || sig.Unit != "A" {
+		t.Fatalf("got %+v, want signed little-endian PackCurrent in A", sig)
+	}
+	mc, ok := db.Messages[0x200]
+	if !ok || mc.Signals[0].LittleEndian {
+		t.Fatalf("got %+v, want the extended-ID motor message with a Motorola signal", mc)
+	}
+}
+
+func TestDBCSignalDecodesBothByteOrders(t *testing.T) {
+	intel := dbcSignal{StartBit: 16, Length: 16, LittleEndian: true, Signed: true, Scale: 0.1}
+	// -25.0 A is raw -250 = 0xFF06, little-endian in bytes 2..3
+	if got, ok := intel.decode([]byte{0, 0, 0x06, 0xFF}); !ok || math.Abs(got+25) > 1e-9 {
+		t.Fatalf("got %g (ok=%v), want -25", got, ok)
+	}
+	motorola := dbcSignal{StartBit: 7, Length: 16, Scale: 1}
+	if got, ok := motorola.decode([]byte{0x12, 0x34}); !ok || got != 0x1234 {
+		t.Fatalf("got %g (ok=%v), want %d", got, ok, 0x1234)
+	}
+	if _, ok := motorola.decode([]byte{0x12}); ok {
+		t.Fatalf("got a value from a short payload")
+	}
+}
+
+func TestDecodeCandumpReadsBothForms(t *testing.T) {
+	text := "(100.000000) can0 400#D0070000C8\n" +
+		"(100.050000)  can0  80000200   [2]  03 E8\n" +
+		"(100.060000) can0 123#R\n"
+	frames, err := decodeCandump(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("decodeCandump returned error: %v", err)
+	}
+	if len(frames) != 2 || frames[0].ID != 0x400 || len(frames[0].Data) != 5 || frames[1].ID != 0x200 || frames[1].Data[1] != 0xE8 {
+		t.Fatalf("got %+v, want two data frames", frames)
+	}
+}
+
+func TestDecodeCANTimelineAlignsChannels(t *testing.T) {
+	db, err := parseDBC(strings.NewReader(testDBC))
+	if err != nil {
+		t.Fatalf("parseDBC returned error: %v", err)
+	}
+	// 100.00 V, +20.0 A, 80 %, 25 degC cell; 600 rpm, 50 degC motor
+	logText := "time_s,id,data\n" +
+		"10.0,0x400,1027C8 00 A0 41 00 00\n" +
+		"10.05,80000200,02 58 5A 00\n" +
+		"10.3,0x400,1027C8 00 A0 41 00 00\n"
+	frames, err := decodeCANLog(strings.Replace(logText, "1027C8 00", "10 27 C8 00", -1), "")
+	if err != nil {
+		t.Fatalf("decodeCANLog returned error: %v", err)
+	}
+
+	got, err := decodeCANTimeline(db, frames, canChannelMap{}, 0.1, 0.25, 0)
+	if err != nil {
+		t.Fatalf("decodeCANTimeline returned error: %v", err)
+	}
+	if len(got.Samples) != 4 || got.StartT != 10 {
+		t.Fatalf("got %d samples from %.2f s, want 4 from 10 s", len(got.Samples), got.StartT)
+	}
+	if got.Channels.VoltageV != "BMS_Pack.PackVoltage" || got.Channels.MotorRPM != "MC_Status.MotorRPM" || len(got.Channels.Temperatures) != 2 {
+		t.Fatalf("got channels %+v, want the guessed BMS and motor signals", got.Channels)
+	}
+	last := got.Samples[3]
+	wantSpeed := 600 * 2 * math.Pi / 60 * 0.25
+	if math.Abs(last.VoltageV-100) > 1e-9 || math.Abs(last.CurrentA-20) > 1e-9 || last.SOC == nil || *last.SOC != 80 || math.Abs(last.Speed-wantSpeed) > 1e-9 {
+		t.Fatalf("got %+v, want 100 V 20 A 80%% at %.3f m/s", last, wantSpeed)
+	}
+	if motor, cell := got.Signals["MC_Status.MotorTemp"][3], got.Signals["BMS_Pack.CellTemp"][3]; motor != 50 || cell != 25 {
+		t.Fatalf("got cell %g and motor %g degC, want 25 and 50", cell, motor)
+	}
+	// motor frames start at 10.05 s; earlier samples hold its first value
+	if got.Samples[0].MotorRPM != 600 || *last.DistanceM <= 0 {
+		t.Fatalf("got first rpm %.0f and distance %.3f m, want 600 rpm and some distance", got.Samples[0].MotorRPM, *last.DistanceM)
+	}
+}
+
+func TestCANDecodeHandlerWritesCSVForTelemetryTools(t *testing.T) {
+	body, err := json.Marshal(canDecodeRequest{
+		DBC:    testDBC,
+		Log:    "(0.0) can0 400#10270000C8\n(0.5) can0 80000200#0258\n(1.0) can0 400#10270000C8\n",
+		Inputs: defaultSimulationInputs(),
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/can/decode?format=csv", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	canDecodeHandler(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("got status %d type %q, want CSV: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	samples, err := decodeRaceLogCSV(strings.NewReader(rec.Body.String()))
+	if err != nil {
+		t.Fatalf("decodeRaceLogCSV returned error: %v", err)
+	}
+	if len(samples) != 11 || samples[10].VoltageV != 100 || samples[10].Speed <= 0 {
+		t.Fatalf("got %d samples, last %+v, want 11 at 100 V and moving", len(samples), samples[len(samples)-1])
+	}
+}
+
+func TestCANDecodeHandlerRejectsOversizedTimelines(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		log     string
+		periodS float64
+	}{
+		{"tiny period", "(0.0) can0 400#10270000C8\n(1.0) can0 400#10270000C8\n", 1e-9},
+		{"stray timestamp", "(0.0) can0 400#10270000C8\n(1700000000.0) can0 400#10270000C8\n", 0},
+		{"NaN timestamp", "(0.0) can0 400#10270000C8\n(NaN) can0 400#10270000C8\n", 0},
+	} {
+		body, err := json.Marshal(canDecodeRequest{DBC: testDBC, Log: tc.log, PeriodS: tc.periodS, Inputs: defaultSimulationInputs()})
+		if err != nil {
+			t.Fatalf("json.Marshal returned error: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/can/decode", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+
+		canDecodeHandler(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d, want %d: %s", tc.name, rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+	}
+}
+
+func TestDecodeCANTimelineRejectsManySignalsOverLongLogs(t *testing.T) {
+	var dbc strings.Builder
+	dbc.WriteString("BO_ 1024 Temps: 8 BMS\n")
+	for i := 0; i < 64; i++ {
+		fmt.Fprintf(&dbc, " SG_ Temp%d : %d|1@1+ (1,0) [0|1] \"degC\" Vector__XXX\n", i, i)
+	}
+	db, err := parseDBC(strings.NewReader(dbc.String()))
+	if err != nil {
+		t.Fatalf("parseDBC returned error: %v", err)
+	}
+	frames := []canFrame{{T: 0, ID: 1024, Data: make([]byte, 8)}, {T: 49999, ID: 1024, Data: make([]byte, 8)}}
+
+	if _, err := decodeCANTimeline(db, frames, canChannelMap{}, 0, 0.25, 0); err == nil {
+		t.Fatal("expected an error for 64 signals over 500000 steps")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const defaultCoastdownMinSpeed = 2.0 // m/s; below this the deceleration is mostly noise and brake drag
+
+// coastdownSample is one logged speed reading during a coastdown run.
+type coastdownSample struct {
+	T float64 `json:"t"` // s
+	V float64 `json:"v"` // m/s
+}
+
+// coastdownRun is one coast from speed with the drive disengaged. Direction
+// labels the heading (e.g. "north"/"south"); runs in opposite directions
+// share Crr and CdA but each direction gets its own grade term.
+type coastdownRun struct {
+	Direction string            `json:"direction"`
+	Samples   []coastdownSample `json:"samples"`
+}
+
+// confidenceInterval is a two-sided 95% interval.
+type confidenceInterval struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+func intervalAround(x, se float64) confidenceInterval {
+	return confidenceInterval{Low: x - z95*se, High: x + z95*se}
+}
+
+// coastdownResult is the resistance fit and the preset it implies.
+type coastdownResult struct {
+	Runs         int                `json:"runs"`
+	Samples      int                `json:"samples"` // decelerations used in the fit
+	Directions   []string           `json:"directions"`
+	Crr          float64            `json:"cRr"`
+	CrrCI        confidenceInterval `json:"cRrCI"`
+	CdA          float64            `json:"cdA"` // m²
+	CdACI        confidenceInterval `json:"cdACI"`
+	Cd           float64            `json:"cD"` // CdA over inputs.A
+	CdCI         confidenceInterval `json:"cDCI"`
+	GradeRad     float64            `json:"gradeRad"` // road grade along the first direction; 0 with one direction
+	RMSEN        float64            `json:"rmseN"`    // resistance force residual
+	R2           float64            `json:"r2"`       // of the force fit
+	Notes        []string           `json:"notes,omitempty"`
+	PresetUpdate simulationInputs   `json:"presetUpdate"` // inputs with Crr and Cd replaced by the fit
+}
+
+// coastdownForces turns a run into (v, resistance force) pairs. The
+// deceleration at each interior sample is a central difference over its
+// neighbours; samples below minSpeed are dropped.
+func coastdownForces(run coastdownRun, massKg, minSpeed float64) ([]float64, []float64, error) {
+	samples := append([]coastdownSample(nil), run.Samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].T < samples[j].T })
+
+	var speeds, forces []float64
+	for i := 1; i+1 < len(samples); i++ {
+		prev, cur, next := samples[i-1], samples[i], samples[i+1]
+		dt := next.T - prev.T
+		if dt <= 0 {
+			return nil, nil, fmt.Errorf("duplicate sample time %g s", cur.T)
+		}
+		if cur.V < minSpeed {
+			continue
+		}
+		decel := -(next.V - prev.V) / dt
+		speeds = append(speeds, cur.V)
+		forces = append(forces, massKg*decel)
+	}
+	return speeds, forces, nil
+}
+
+// fitCoastdown fits the PowerRequired resistance model to coastdown runs.
+// With the drive out the car slows under
+//
+//	m_eff·a = (Crr·m·g + m·g·sin θ + ½·ρ·CdA·v²)·(1 + additionalEfficiency/100)
+//
+// so the force is linear in v² with one intercept per direction: averaging
+// the intercepts cancels the grade, and half their difference is the grade.
+// m_eff adds rotationalMassKg (wheels, motor) to inputs.M; inputs.Rho should
+// be the air density on the day. Wind is assumed to average out across
+// directions.
+func fitCoastdown(inputs simulationInputs, runs []coastdownRun, minSpeed, rotationalMassKg float64) (coastdownResult, error) {
+	if len(runs) == 0 {
+		return coastdownResult{}, fmt.Errorf("no coastdown runs")
+	}
+	if minSpeed <= 0 {
+		minSpeed = defaultCoastdownMinSpeed
+	}
+	if rotationalMassKg < 0 {
+		return coastdownResult{}, fmt.Errorf("rotational mass must be >= 0")
+	}
+
+	var directions []string
+	directionIndex := map[string]int{}
+	for _, run := range runs {
+		if _, ok := directionIndex[run.Direction]; !ok {
+			directionIndex[run.Direction] = len(directions)
+			directions = append(directions, run.Direction)
+		}
+	}
+	if len(directions) > 2 {
+		return coastdownResult{}, fmt.Errorf("coastdown runs need at most two directions, got %d", len(directions))
+	}
+
+	// columns: one intercept per direction, then v²
+	var x [][]float64
+	var y []float64
+	for i, run := range runs {
+		speeds, forces, err := coastdownForces(run, inputs.M+rotationalMassKg, minSpeed)
+		if err != nil {
+			return coastdownResult{}, fmt.Errorf("run %d: %w", i+1, err)
+		}
+		for k, v := range speeds {
+			row := make([]float64, len(directions)+1)
+			row[directionIndex[run.Direction]] = 1
+			row[len(directions)] = v * v
+			x = append(x, row)
+			y = append(y, forces[k])
+		}
+	}
+	fit, err := fitLeastSquares(x, y)
+	if err != nil {
+		return coastdownResult{}, err
+	}
+
+	scale := 1 + inputs.AdditionalEfficiency/100
+	weight := inputs.M * inputs.G * scale
+	nd := len(directions)
+	result := coastdownResult{
+		Runs:       len(runs),
+		Samples:    fit.N,
+		Directions: directions,
+		RMSEN:      fit.rmse(),
+		R2:         fit.r2(),
+	}
+
+	// Crr from the mean intercept; its variance includes the covariance
+	// between the two direction terms
+	meanIntercept, interceptVar := 0.0, 0.0
+	for i := 0; i < nd; i++ {
+		meanIntercept += fit.Coef[i] / float64(nd)
+		for j := 0; j < nd; j++ {
+			interceptVar += fit.Cov[i][j] / float64(nd*nd)
+		}
+	}
+	result.Crr = meanIntercept / weight
+	result.CrrCI = intervalAround(result.Crr, math.Sqrt(interceptVar)/weight)
+
+	aeroScale := 0.5 * inputs.Rho * scale
+	result.CdA = fit.Coef[nd] / aeroScale
+	cdaSE := math.Sqrt(fit.Cov[nd][nd]) / aeroScale
+	result.CdACI = intervalAround(result.CdA, cdaSE)
+	result.Cd = result.CdA / inputs.A
+	result.CdCI = intervalAround(result.Cd, cdaSE/inputs.A)
+
+	if nd == 2 {
+		result.GradeRad = math.Asin(math.Max(-1, math.Min(1, (fit.Coef[0]-fit.Coef[1])/2/weight)))
+	} else {
+		result.Notes = append(result.Notes, "runs in one direction only: Crr includes any road grade")
+	}
+	if result.Crr <= 0 {
+		result.Notes = append(result.Notes, "fitted Crr is not positive; check the logs for driveline drag or braking")
+	}
+	if result.CdA <= 0 {
+		result.Notes = append(result.Notes, "fitted CdA is not positive; the runs may not cover enough speed range")
+	}
+
+	result.PresetUpdate = inputs
+	result.PresetUpdate.Crr = result.Crr
+	result.PresetUpdate.Cd = result.Cd
+	return result, nil
+}
+
+// decodeCoastdownCSV reads coastdown logs with the header
+// run,direction,time_s,speed_mps; rows with the same run id form one run.
+func decodeCoastdownCSV(r io.Reader) ([]coastdownRun, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("coastdown CSV has no data rows")
+	}
+
+	var runs []coastdownRun
+	runIndex := map[string]int{}
+	for i, row := range rows[1:] {
+		if len(row) < 4 {
+			return nil, fmt.Errorf("coastdown CSV row %d: want 4 columns, got %d", i+2, len(row))
+		}
+		t, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("coastdown CSV row %d: %w", i+2, err)
+		}
+		v, err := strconv.ParseFloat(row[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("coastdown CSV row %d: %w", i+2, err)
+		}
+		k, ok := runIndex[row[0]]
+		if !ok {
+			k = len(runs)
+			runIndex[row[0]] = k
+			runs = append(runs, coastdownRun{Direction: row[1]})
+		}
+		if runs[k].Direction != row[1] {
+			return nil, fmt.Errorf("coastdown CSV row %d: run %s changes direction", i+2, row[0])
+		}
+		runs[k].Samples = append(runs[k].Samples, coastdownSample{T: t, V: v})
+	}
+	return runs, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// syntheticCoastdown integrates a coast from v0 under the PowerRequired
+// resistance model on a road of the given grade, logging every dt seconds.
+func syntheticCoastdown(inputs simulationInputs, direction string, v0, grade, dt float64) coastdownRun {
+	const substeps = 100
+	run := coastdownRun{Direction: direction}
+	v, t := v0, 0.0
+	for v > 1 {
+		run.Samples = append(run.Samples, coastdownSample{T: t, V: v})
+		for i := 0; i < substeps; i++ {
+			v += coastDecel(v, 0.1, inputs.M, inputs.G, inputs.Crr, inputs.Rho, inputs.Cd, inputs.A, grade, inputs.AdditionalEfficiency) * dt / substeps
+		}
+		t += dt
+	}
+	return run
+}
+
+func TestFitCoastdownRecoversCrrAndCdA(t *testing.T) {
+	truth := defaultSimulationInputs()
+	truth.Crr, truth.Cd = 0.0042, 0.18
+	const grade = 0.004
+	runs := []coastdownRun{
+		syntheticCoastdown(truth, "north", 25, grade, 0.5),
+		syntheticCoastdown(truth, "south", 25, -grade, 0.5),
+		syntheticCoastdown(truth, "north", 20, grade, 0.5),
+		syntheticCoastdown(truth, "south", 20, -grade, 0.5),
+	}
+
+	got, err := fitCoastdown(defaultSimulationInputs(), runs, 0, 0)
+	if err != nil {
+		t.Fatalf("fitCoastdown returned error: %v", err)
+	}
+	if math.Abs(got.Crr-truth.Crr) > 1e-4 {
+		t.Fatalf("got Crr %.5f, want %.5f", got.Crr, truth.Crr)
+	}
+	if math.Abs(got.Cd-truth.Cd) > 2e-3 {
+		t.Fatalf("got Cd %.4f, want %.4f", got.Cd, truth.Cd)
+	}
+	if math.Abs(got.GradeRad-grade) > 1e-4 {
+		t.Fatalf("got grade %.5f rad, want %.5f", got.GradeRad, grade)
+	}
+	if got.CrrCI.Low > got.Crr || got.CrrCI.High < got.Crr || got.CdCI.Low > got.Cd || got.CdCI.High < got.Cd {
+		t.Fatalf("got intervals %+v and %+v, want them around the estimates", got.CrrCI, got.CdCI)
+	}
+	if got.PresetUpdate.Crr != got.Crr || got.PresetUpdate.Cd != got.Cd || got.PresetUpdate.BatteryWh != truth.BatteryWh {
+		t.Fatalf("got preset update %+v, want the defaults with the fitted Crr and Cd", got.PresetUpdate)
+	}
+}
+
+func TestFitCoastdownOneDirectionAddsNote(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	got, err := fitCoastdown(inputs, []coastdownRun{syntheticCoastdown(inputs, "east", 25, 0, 0.5)}, 0, 0)
+	if err != nil {
+		t.Fatalf("fitCoastdown returned error: %v", err)
+	}
+	if len(got.Notes) == 0 || got.GradeRad != 0 {
+		t.Fatalf("got notes %v grade %.5f, want a one-direction note and no grade", got.Notes, got.GradeRad)
+	}
+}
+
+func TestDecodeCoastdownCSVGroupsRuns(t *testing.T) {
+	csv := "run,direction,time_s,speed_mps\n1,north,0,20\n1,north,1,19.5\n2,south,0,20\n1,north,2,19.1\n"
+	runs, err := decodeCoastdownCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("decodeCoastdownCSV returned error: %v", err)
+	}
+	if len(runs) != 2 || len(runs[0].Samples) != 3 || runs[1].Direction != "south" {
+		t.Fatalf("got %+v, want a 3-sample north run and a south run", runs)
+	}
+
+	if _, err := decodeCoastdownCSV(strings.NewReader("run,direction,time_s,speed_mps\n1,north,0,20\n1,south,1,19\n")); err == nil {
+		t.Fatalf("got no error for a run that changes direction")
+	}
+}
+
+func TestCoastdownHandlerReturnsPresetUpdate(t *testing.T) {
+	truth := defaultSimulationInputs()
+	truth.Crr = 0.003
+	body, err := json.Marshal(coastdownRequest{
+		Inputs: defaultSimulationInputs(),
+		Runs:   []coastdownRun{syntheticCoastdown(truth, "north", 25, 0, 1), syntheticCoastdown(truth, "south", 25, 0, 1)},
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/calibrate/coastdown", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	coastdownHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got coastdownResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || math.Abs(got.PresetUpdate.Crr-0.003) > 1e-4 {
+		t.Fatalf("got ok=%v Crr %.5f, want a preset update with Crr near 0.003", got.OK, got.PresetUpdate.Crr)
+	}
+}
+
+func TestCoastdownHandlerRejectsEmptyRuns(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/calibrate/coastdown", strings.NewReader(`{"runs":[]}`))
+	rec := httptest.NewRecorder()
+
+	coastdownHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDashTopic    = "flare/dash"
+	defaultDashClientID = "flare-sim"
+	dashDialTimeout     = 5 * time.Second
+	dashWriteTimeout    = time.Second // a slow dash must not hold up the samples request
+)
+
+// dashCue is what the driver needs for one track segment: its target speed
+// and where to lift or brake before it. Distances are from the start line
+// along the model lap; the coast and brake zones end at StartM and may wrap
+// back over the line.
+type dashCue struct {
+	Segment      int     `json:"segment"`
+	Type         string  `json:"type"`
+	StartM       float64 `json:"startM"`
+	EndM         float64 `json:"endM"`
+	TargetV      float64 `json:"targetV"`      // m/s, lowest planned cap over the segment
+	CoastStartM  float64 `json:"coastStartM"`  // lift here to coast down to TargetV
+	CoastLengthM float64 `json:"coastLengthM"` // 0 when the segment needs no lift
+	BrakeStartM  float64 `json:"brakeStartM"`  // latest braking point if the coast is missed
+	BrakeLengthM float64 `json:"brakeLengthM"` // 0 when the segment needs no braking
+}
+
+// dashPlan is the cue list for one lap at the planned cruise speed.
+type dashPlan struct {
+	CruiseV    float64   `json:"cruiseV"`
+	LapLengthM float64   `json:"lapLengthM"`
+	Cues       []dashCue `json:"cues"`
+}
+
+// buildDashPlan derives the dash cues from the same base, brake and coast
+// profiles the telemetry lap is driven with. A segment's coast zone is the
+// run of metres before it where the coast-feasible speed sits below the
+// base cap; the brake zone is the same for the brake-feasible speed.
+func buildDashPlan(segments []trackSegment, inputs simulationInputs) (dashPlan, error) {
+	const (
+		stepM = 1.0 // as in the telemetry lap
+		vMin  = 0.5
+		eps   = 1e-6
+	)
+	track := telemetryTrackFromSegments(segments)
+	cruiseCap := math.Min(telemetryMaxSpeed, inputs.V)
+	if cruiseCap <= 0 {
+		cruiseCap = telemetryMaxSpeed
+	}
+	profiles, err := buildTelemetryProfiles(
+		track,
+		true,
+		inputs.SpeedPlan,
+		stepM,
+		inputs.Gmax,
+		cruiseCap,
+		0.95*inputs.G,
+		vMin,
+		inputs.M,
+		inputs.G,
+		inputs.Crr,
+		inputs.Rho,
+		inputs.Cd,
+		inputs.A,
+		inputs.Theta,
+		inputs.AdditionalEfficiency,
+	)
+	if err != nil {
+		return dashPlan{}, err
+	}
+	samples := sampleTrackMeters(track, stepM, inputs.G, inputs.Gmax)
+	n := len(samples)
+	if n == 0 || len(profiles.Base) != n || len(profiles.Brake) != n || len(profiles.Coast) != n {
+		return dashPlan{}, fmt.Errorf("track has no drivable length")
+	}
+	last := samples[n-1]
+	plan := dashPlan{CruiseV: cruiseCap, LapLengthM: last.TrackDistanceM + last.StepLengthM}
+
+	// zone walks back from the first sample of a segment while limit is
+	// below the base cap and returns where the run starts and its length.
+	// A run that carries on into the segment belongs to a later one.
+	zone := func(limit speedProfile, first int) (float64, float64) {
+		start, length := samples[first].TrackDistanceM, 0.0
+		if limit[first] < profiles.Base[first]-eps {
+			return start, 0
+		}
+		for k := 1; k < n; k++ {
+			j := (first - k + n) % n
+			if limit[j] >= profiles.Base[j]-eps {
+				break
+			}
+			start = samples[j].TrackDistanceM
+			length += samples[j].StepLengthM
+		}
+		return start, length
+	}
+
+	for first := 0; first < n; {
+		seg := samples[first].SegmentIndex
+		end := first
+		target := math.Inf(1)
+		for end < n && samples[end].SegmentIndex == seg {
+			target = math.Min(target, profiles.Base[end])
+			end++
+		}
+		cue := dashCue{
+			Segment: seg,
+			StartM:  samples[first].TrackDistanceM,
+			EndM:    samples[end-1].TrackDistanceM + samples[end-1].StepLengthM,
+			TargetV: target,
+		}
+		if seg < len(segments) {
+			cue.Type = segments[seg].Type
+		}
+		cue.CoastStartM, cue.CoastLengthM = zone(profiles.Coast, first)
+		cue.BrakeStartM, cue.BrakeLengthM = zone(profiles.Brake, first)
+		plan.Cues = append(plan.Cues, cue)
+		first = end
+	}
+	return plan, nil
+}
+
+// dashMessage is one datagram or MQTT payload for the dash. The "in"
+// distances count down from the car's position and go negative once the
+// point has been passed; when the next segment needs no lift or braking
+// they equal NextInM.
+type dashMessage struct {
+	Seq          uint64  `json:"seq"`
+	ElapsedS     float64 `json:"elapsedS"`
+	LapDistanceM float64 `json:"lapDistanceM"`
+	Speed        float64 `json:"speed"`   // m/s, latest from the car
+	TargetV      float64 `json:"targetV"` // current segment
+	NextSegment  int     `json:"nextSegment"`
+	NextType     string  `json:"nextType"`
+	NextTargetV  float64 `json:"nextTargetV"`
+	NextInM      float64 `json:"nextInM"`
+	CoastInM     float64 `json:"coastInM"`
+	BrakeInM     float64 `json:"brakeInM"`
+}
+
+// message places distanceM (cumulative, any number of laps) on the lap and
+// fills in the current and next cues.
+func (p dashPlan) message(elapsedS, distanceM, speed float64) dashMessage {
+	d := math.Mod(math.Max(distanceM, 0), p.LapLengthM)
+	cur := 0
+	for i, cue := range p.Cues {
+		if cue.StartM <= d {
+			cur = i
+		}
+	}
+	next := p.Cues[(cur+1)%len(p.Cues)]
+	nextIn := next.StartM - d
+	if nextIn <= 0 {
+		nextIn += p.LapLengthM
+	}
+	return dashMessage{
+		ElapsedS:     elapsedS,
+		LapDistanceM: d,
+		Speed:        speed,
+		TargetV:      p.Cues[cur].TargetV,
+		NextSegment:  next.Segment,
+		NextType:     next.Type,
+		NextTargetV:  next.TargetV,
+		NextInM:      nextIn,
+		CoastInM:     nextIn - next.CoastLengthM,
+		BrakeInM:     nextIn - next.BrakeLengthM,
+	}
+}
+
+// dashAllowedHosts is a comma-separated list of dash and broker hosts
+// allowed besides loopback and private addresses. The server's -dash-hosts
+// flag sets it.
+var dashAllowedHosts = ""
+
+// dashTarget is where dash messages go: UDP JSON datagrams to UDP, or MQTT
+// publishes to the broker at MQTT. Exactly one must be set.
+type dashTarget struct {
+	UDP      string `json:"udp,omitempty"`      // host:port
+	MQTT     string `json:"mqtt,omitempty"`     // broker host:port, MQTT 3.1.1 without TLS or auth
+	Topic    string `json:"topic,omitempty"`    // default flare/dash
+	ClientID string `json:"clientId,omitempty"` // default flare-sim
+
+	addr string // resolved UDP or MQTT address, set by validate
+}
+
+func (t *dashTarget) validate() error {
+	if (t.UDP == "") == (t.MQTT == "") {
+		return fmt.Errorf("set exactly one of udp or mqtt")
+	}
+	addr, err := resolveDashAddr(t.UDP + t.MQTT)
+	if err != nil {
+		return err
+	}
+	t.addr = addr
+	if t.MQTT != "" {
+		if t.Topic == "" {
+			t.Topic = defaultDashTopic
+		}
+		if t.ClientID == "" {
+			t.ClientID = defaultDashClientID
+		}
+	}
+	return nil
+}
+
+// resolveDashAddr returns the address to dial for a dash at addr. The dash
+// sits on the car or in the pits, so the server only sends to loopback and
+// private addresses, plus the hosts in dashAllowedHosts; it dials the
+// address it checked so a second lookup cannot point elsewhere.
+func resolveDashAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("dash address %q must be host:port", addr)
+	}
+	for _, allowed := range strings.Split(dashAllowedHosts, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, host) {
+			return addr, nil
+		}
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("dash host %q does not resolve", host)
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() && !ip.IsPrivate() {
+			return "", fmt.Errorf("dash host %q is not a loopback or private address; allow it with -dash-hosts", host)
+		}
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// dashPublisher sends one encoded message to the dash.
+type dashPublisher interface {
+	publish(payload []byte) error
+	Close() error
+}
+
+func dialDash(t dashTarget) (dashPublisher, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	if t.UDP != "" {
+		conn, err := net.DialTimeout("udp", t.addr, dashDialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &udpDashPublisher{conn: conn}, nil
+	}
+	p := &mqttDashPublisher{target: t}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// udpDashPublisher sends each message as one JSON datagram.
+type udpDashPublisher struct {
+	conn net.Conn
+}
+
+func (p *udpDashPublisher) publish(payload []byte) error {
+	p.conn.SetWriteDeadline(time.Now().Add(dashWriteTimeout))
+	_, err := p.conn.Write(payload)
+	return err
+}
+
+func (p *udpDashPublisher) Close() error {
+	return p.conn.Close()
+}
+
+// mqttDashPublisher is just enough MQTT 3.1.1 for the dash: a clean-session
+// CONNECT and QoS 0 PUBLISH. Keep-alive is off, since the broker only hears
+// from us when samples arrive; a dropped connection is redialled once on the
+// next publish.
+type mqttDashPublisher struct {
+	target dashTarget
+	conn   net.Conn
+	closed bool // set by Close; publish must not redial after it
+}
+
+// mqttPacket frames an MQTT control packet with its remaining length.
+func mqttPacket(header byte, body []byte) []byte {
+	out := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// mqttString is an MQTT length-prefixed UTF-8 string.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func (p *mqttDashPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.target.addr, dashDialTimeout)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	body.Write(mqttString("MQTT"))
+	body.Write([]byte{4, 0x02, 0, 0}) // protocol level 4, clean session, keep-alive off
+	body.Write(mqttString(p.target.ClientID))
+	conn.SetDeadline(time.Now().Add(dashDialTimeout))
+	if _, err := conn.Write(mqttPacket(0x10, body.Bytes())); err != nil {
+		conn.Close()
+		return err
+	}
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		conn.Close()
+		return fmt.Errorf("mqtt broker did not acknowledge: %w", err)
+	}
+	if ack[0] != 0x20 || ack[1] != 2 || ack[3] != 0 {
+		conn.Close()
+		return fmt.Errorf("mqtt broker refused the connection (code %d)", ack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	p.conn = conn
+	return nil
+}
+
+func (p *mqttDashPublisher) publish(payload []byte) error {
+	if p.closed {
+		return fmt.Errorf("mqtt dash publisher is closed")
+	}
+	packet := mqttPacket(0x30, append(mqttString(p.target.Topic), payload...))
+	write := func() error {
+		p.conn.SetWriteDeadline(time.Now().Add(dashWriteTimeout))
+		_, err := p.conn.Write(packet)
+		return err
+	}
+	if p.conn != nil {
+		if err := write(); err == nil {
+			return nil
+		}
+		p.conn.Close()
+		p.conn = nil
+	}
+	if err := p.connect(); err != nil {
+		return err
+	}
+	return write()
+}
+
+func (p *mqttDashPublisher) Close() error {
+	p.closed = true
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.Write(mqttPacket(0xE0, nil))
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// dashBroadcast publishes a live session's position against its dash plan.
+type dashBroadcast struct {
+	mu     sync.Mutex
+	Plan   dashPlan
+	Target dashTarget
+	pub    dashPublisher
+	seq    uint64
+}
+
+// send numbers msg and publishes it as JSON.
+func (b *dashBroadcast) send(msg dashMessage) (dashMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	msg.Seq = b.seq
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return msg, err
+	}
+	return msg, b.pub.publish(payload)
+}
+
+func (b *dashBroadcast) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pub.Close()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDashPlanCuesCurvesFromTheProfiles(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = 20
+	plan, err := buildDashPlan(defaultTrackSegments(), inputs)
+	if err != nil {
+		t.Fatalf("buildDashPlan returned error: %v", err)
+	}
+	if len(plan.Cues) == 0 || plan.Cues[0].StartM != 0 {
+		t.Fatalf("got %d cues, want cues from the start line", len(plan.Cues))
+	}
+	lifts := 0
+	for _, cue := range plan.Cues {
+		if cue.TargetV > plan.CruiseV+1e-9 {
+			t.Fatalf("segment %d: got target %.2f m/s, want at most the %.2f m/s cruise", cue.Segment, cue.TargetV, plan.CruiseV)
+		}
+		if cue.BrakeLengthM > cue.CoastLengthM {
+			t.Fatalf("segment %d: got a %.0f m brake zone longer than the %.0f m coast", cue.Segment, cue.BrakeLengthM, cue.CoastLengthM)
+		}
+		if cue.CoastLengthM > 0 {
+			lifts++
+			if end := math.Mod(cue.CoastStartM+cue.CoastLengthM, plan.LapLengthM); math.Abs(end-cue.StartM) > 1e-6 {
+				t.Fatalf("segment %d: coast zone ends at %.1f m, want %.1f m", cue.Segment, end, cue.StartM)
+			}
+			if cue.Type != "curve" {
+				t.Fatalf("segment %d: got a lift before a %s, want only before curves", cue.Segment, cue.Type)
+			}
+		}
+	}
+	if lifts == 0 {
+		t.Fatalf("got no coast points at %.0f m/s on the default track", inputs.V)
+	}
+}
+
+func TestDashPlanMessageCountsDownToTheNextCue(t *testing.T) {
+	plan := dashPlan{LapLengthM: 1000, Cues: []dashCue{
+		{Segment: 0, Type: "straight", StartM: 0, EndM: 600, TargetV: 20},
+		{Segment: 1, Type: "curve", StartM: 600, EndM: 1000, TargetV: 12, CoastStartM: 450, CoastLengthM: 150, BrakeStartM: 560, BrakeLengthM: 40},
+	}}
+
+	got := plan.message(90, 2500, 19)
+	if got.LapDistanceM != 500 || got.TargetV != 20 || got.NextSegment != 1 || got.NextTargetV != 12 {
+		t.Fatalf("got %+v, want 500 m into the straight heading for the curve", got)
+	}
+	if got.NextInM != 100 || got.CoastInM != -50 || got.BrakeInM != 60 {
+		t.Fatalf("got next %.0f coast %.0f brake %.0f m, want 100, -50 and 60", got.NextInM, got.CoastInM, got.BrakeInM)
+	}
+
+	got = plan.message(100, 700, 12)
+	if got.NextSegment != 0 || got.NextInM != 300 || got.CoastInM != 300 {
+		t.Fatalf("got %+v, want the start line 300 m ahead with no lift", got)
+	}
+}
+
+// mqttStandIn accepts one connection, acknowledges its CONNECT and returns
+// the first PUBLISH's topic and payload.
+func mqttStandIn(t *testing.T) (string, <-chan [2]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan [2]string, 1)
+	go func() {
+		defer close(got)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		readPacket := func() (byte, []byte, bool) {
+			header, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, false
+			}
+			n, shift := 0, 0
+			for {
+				b, err := r.ReadByte()
+				if err != nil {
+					return 0, nil, false
+				}
+				n |= int(b&0x7f) << shift
+				shift += 7
+				if b&0x80 == 0 {
+					break
+				}
+			}
+			body := make([]byte, n)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return 0, nil, false
+			}
+			return header, body, true
+		}
+		if header, body, ok := readPacket(); !ok || header != 0x10 || !bytes.HasPrefix(body, []byte("\x00\x04MQTT\x04")) {
+			return
+		}
+		conn.Write([]byte{0x20, 2, 0, 0})
+		header, body, ok := readPacket()
+		if !ok || header != 0x30 {
+			return
+		}
+		topicLen := int(body[0])<<8 | int(body[1])
+		got <- [2]string{string(body[2 : 2+topicLen]), string(body[2+topicLen:])}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestMQTTDashPublisherPublishesToTheBroker(t *testing.T) {
+	addr, got := mqttStandIn(t)
+	pub, err := dialDash(dashTarget{MQTT: addr})
+	if err != nil {
+		t.Fatalf("dialDash returned error: %v", err)
+	}
+	defer pub.Close()
+	if err := pub.publish([]byte(`{"seq":1}`)); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	msg := <-got
+	if msg[0] != defaultDashTopic || msg[1] != `{"seq":1}` {
+		t.Fatalf("got topic %q payload %q, want %q and the message", msg[0], msg[1], defaultDashTopic)
+	}
+}
+
+func TestMQTTDashPublisherDoesNotRedialOnceClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 64))
+		conn.Write([]byte{0x20, 2, 0, 0})
+		io.Copy(io.Discard, conn)
+	}()
+	pub, err := dialDash(dashTarget{MQTT: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("dialDash returned error: %v", err)
+	}
+	pub.Close()
+
+	if err := pub.publish([]byte(`{"seq":1}`)); err == nil {
+		t.Fatal("expected an error publishing after Close")
+	}
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if conn, err := ln.Accept(); err == nil {
+		conn.Close()
+		t.Fatal("got a new broker connection after Close, want none")
+	}
+}
+
+func TestLiveDashHandlerBroadcastsSamplesOverUDP(t *testing.T) {
+	dash, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer dash.Close()
+	session := newTestLiveSession(t)
+
+	body, err := json.Marshal(dashTarget{UDP: dash.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/live/sessions/"+session.ID+"/dash", bytes.NewReader(body))
+	req.SetPathValue("id", session.ID)
+	rec := httptest.NewRecorder()
+
+	liveDashHandler(rec, req)
+
+	var started dashResponse
+	if err := json.NewDecoder(rec.Body).Decode(&started); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if rec.Code != http.StatusOK || !started.OK || started.Plan == nil || len(started.Plan.Cues) == 0 {
+		t.Fatalf("got status %d %+v, want a dash plan", rec.Code, started)
+	}
+
+	code, got := postLiveSamples(t, session.ID, onPlanSamples(*session.Plan, 0, 60, 1))
+	if code != http.StatusOK || got.Dash == nil || got.Dash.Seq != 1 {
+		t.Fatalf("got status %d dash %+v, want the first message", code, got.Dash)
+	}
+
+	dash.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := dash.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom returned error: %v", err)
+	}
+	var sent dashMessage
+	if err := json.Unmarshal(buf[:n], &sent); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if sent != *got.Dash || sent.ElapsedS != 60 || sent.NextTargetV <= 0 {
+		t.Fatalf("got datagram %+v, want %+v", sent, *got.Dash)
+	}
+}
+
+func TestDashTargetRejectsPublicAddresses(t *testing.T) {
+	for _, target := range []dashTarget{
+		{UDP: "8.8.8.8:53"},
+		{MQTT: "169.254.169.254:80"},
+		{UDP: "[2001:4860:4860::8888]:53"},
+		{MQTT: "broker"},
+	} {
+		if err := target.validate(); err == nil {
+			t.Fatalf("%+v: expected the target to be rejected", target)
+		}
+	}
+
+	ok := dashTarget{UDP: "192.168.1.20:9000"}
+	if err := ok.validate(); err != nil {
+		t.Fatalf("validate returned error for a private address: %v", err)
+	}
+
+	prev := dashAllowedHosts
+	t.Cleanup(func() { dashAllowedHosts = prev })
+	dashAllowedHosts = "203.0.113.7, dash.example.org"
+	allowed := dashTarget{MQTT: "203.0.113.7:1883"}
+	if err := allowed.validate(); err != nil || allowed.addr != "203.0.113.7:1883" {
+		t.Fatalf("got %q, %v for an allowed host, want it dialled as given", allowed.addr, err)
+	}
+}
+
+func TestLiveDashHandlerRejectsPublicTarget(t *testing.T) {
+	session := newTestLiveSession(t)
+	req := httptest.NewRequest(http.MethodPost, "/live/sessions/"+session.ID+"/dash", strings.NewReader(`{"udp":"8.8.8.8:53"}`))
+	req.SetPathValue("id", session.ID)
+	rec := httptest.NewRecorder()
+
+	liveDashHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
@@ -20,6 +20,10 @@ type simulationInputs struct {
 	Theta                float64 `json:"theta"`
 	Gmax                 float64 `json:"gmax"`
 	AdditionalEfficiency float64 `json:"additionalEfficiency"`
+	MinReserveWh         float64 `json:"minReserveWh"`  // battery that must be left at the end of the race
+	MinReservePct        float64 `json:"minReservePct"` // same, as % of BatteryWh; the larger of the two applies
+
+	SpeedPlan []float64 `json:"-"` // per-segment target speeds from a strategy; nil cruises at V
 }
 
 type simulationPreset struct {
@@ -80,6 +84,16 @@ var simulationPresets = []simulationPreset{
 	},
 }
 
+// reserveWh is the end-of-race battery reserve the race may not dip into.
+func (in simulationInputs) reserveWh() float64 {
+	return max(in.MinReserveWh, in.MinReservePct/100*in.BatteryWh, 0)
+}
+
+// usableBatteryWh is the battery available to the race above the reserve.
+func (in simulationInputs) usableBatteryWh() float64 {
+	return max(in.BatteryWh-in.reserveWh(), 0)
+}
+
 func defaultSimulationPreset() simulationPreset {
 	for _, preset := range simulationPresets {
 		if preset.ID == defaultPresetID {
@@ -99,3 +113,17 @@ func simulationDefaultsResponse() defaultsResponse {
 		Presets:         simulationPresets,
 	}
 }
+
+// defaultSolarSite is the Gainesville test site with the Flare array.
+func defaultSolarSite() solarSite {
+	return solarSite{
+		Lat:          29.6516,
+		Lon:          -82.3248,
+		Timezone:     "America/New_York",
+		ForecastDays: 1,
+		PanelArea:    4.0,  // m²
+		PanelEff:     0.22, // cell efficiency
+		SystemEff:    0.9,
+		PV:           defaultPVModel(),
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import "math"
+
+// energyBreakdown splits battery energy [Wh] into where it went. The resistance
+// terms are the pieces of PowerRequired; Drivetrain is the 1/EtaDrive loss on
+// top of the wheel energy; Braking is kinetic energy thrown away when the car
+// slows faster than the resistances alone would. Kinetic is the net change in
+// the car's kinetic energy (about zero over a settled lap), so
+//
+//	Battery = Aero + Rolling + Grade + Penalty + Drivetrain + Braking + Kinetic
+type energyBreakdown struct {
+	AeroWh       float64 `json:"aeroWh"`
+	RollingWh    float64 `json:"rollingWh"`
+	GradeWh      float64 `json:"gradeWh"`
+	PenaltyWh    float64 `json:"penaltyWh"` // AdditionalEfficiency share of the resistances
+	DrivetrainWh float64 `json:"drivetrainWh"`
+	BrakingWh    float64 `json:"brakingWh"`
+	KineticWh    float64 `json:"kineticWh"`
+	BatteryWh    float64 `json:"batteryWh"`
+}
+
+func (b *energyBreakdown) add(o energyBreakdown) {
+	b.AeroWh += o.AeroWh
+	b.RollingWh += o.RollingWh
+	b.GradeWh += o.GradeWh
+	b.PenaltyWh += o.PenaltyWh
+	b.DrivetrainWh += o.DrivetrainWh
+	b.BrakingWh += o.BrakingWh
+	b.KineticWh += o.KineticWh
+	b.BatteryWh += o.BatteryWh
+}
+
+// segmentEnergy is the breakdown for one track segment of a lap.
+type segmentEnergy struct {
+	Segment int     `json:"segment"`
+	Type    string  `json:"type"`
+	StartM  float64 `json:"startM"`
+	LengthM float64 `json:"lengthM"`
+	energyBreakdown
+}
+
+// stepEnergyBreakdown splits one telemetry step from v0 to v1 over ds using
+// the same resistance model as PowerRequired (evaluated at the mean speed) and
+// the same no-regen rule as the telemetry battery channel.
+func stepEnergyBreakdown(v0, v1, ds float64, inputs simulationInputs) energyBreakdown {
+	vAvg := 0.5 * (v0 + v1)
+	const jToWh = 1 / 3600.0
+
+	rollingJ := inputs.Crr * inputs.M * inputs.G * ds
+	gradeJ := inputs.M * inputs.G * math.Sin(inputs.Theta) * ds
+	aeroJ := 0.5 * inputs.Rho * inputs.Cd * inputs.A * vAvg * vAvg * ds
+	penaltyJ := (rollingJ + gradeJ + aeroJ) * inputs.AdditionalEfficiency / 100
+	kineticJ := 0.5 * inputs.M * (v1*v1 - v0*v0)
+
+	b := energyBreakdown{
+		AeroWh:    aeroJ * jToWh,
+		RollingWh: rollingJ * jToWh,
+		GradeWh:   gradeJ * jToWh,
+		PenaltyWh: penaltyJ * jToWh,
+		KineticWh: kineticJ * jToWh,
+	}
+	wheelJ := kineticJ + rollingJ + gradeJ + aeroJ + penaltyJ
+	if wheelJ > 0 {
+		batteryJ := wheelJ / inputs.EtaDrive
+		b.BatteryWh = batteryJ * jToWh
+		b.DrivetrainWh = (batteryJ - wheelJ) * jToWh
+	} else {
+		b.BrakingWh = -wheelJ * jToWh
+	}
+	return b
+}
+
+// lapEnergyBreakdown totals the breakdown over a telemetry lap and per track
+// segment. Points must come from the telemetry builder for segments so their
+// Segment indexes line up.
+func lapEnergyBreakdown(points []telemetryPoint, segments []trackSegment, inputs simulationInputs) (energyBreakdown, []segmentEnergy) {
+	var total energyBreakdown
+	perSegment := make([]segmentEnergy, len(segments))
+	start := 0.0
+	for i, seg := range segments {
+		perSegment[i] = segmentEnergy{Segment: i, Type: seg.Type, StartM: start}
+		start += getTotalLength(telemetryTrackFromSegments([]trackSegment{seg}))
+	}
+
+	for i := 1; i < len(points); i++ {
+		ds := points[i].Distance - points[i-1].Distance
+		if ds <= 0 {
+			continue
+		}
+		step := stepEnergyBreakdown(points[i-1].Speed, points[i].Speed, ds, inputs)
+		total.add(step)
+		if idx := points[i].Segment; idx >= 0 && idx < len(perSegment) {
+			perSegment[idx].energyBreakdown.add(step)
+			perSegment[idx].LengthM += ds
+		}
+	}
+	return total, perSegment
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLapEnergyBreakdownBalancesAndMatchesLapEnergy(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.AdditionalEfficiency = 5
+	inputs.V = computeOptimalSpeedForInputs(inputs)
+	segments := defaultTrackSegments()
+
+	points, err := buildTelemetryForInputs(segments, true, inputs)
+	if err != nil {
+		t.Fatalf("buildTelemetryForInputs returned error: %v", err)
+	}
+	total, perSegment := lapEnergyBreakdown(points, segments, inputs)
+
+	_, wantBattery := lapTimeAndEnergy(points, inputs)
+	if math.Abs(total.BatteryWh-wantBattery) > 1e-6 {
+		t.Fatalf("got battery %.6f Wh, want lap energy %.6f", total.BatteryWh, wantBattery)
+	}
+
+	sum := total.AeroWh + total.RollingWh + total.GradeWh + total.PenaltyWh +
+		total.DrivetrainWh + total.BrakingWh + total.KineticWh
+	if math.Abs(sum-total.BatteryWh) > 1e-6 {
+		t.Fatalf("got terms summing to %.6f Wh, want battery %.6f", sum, total.BatteryWh)
+	}
+	if total.AeroWh <= 0 || total.RollingWh <= 0 || total.DrivetrainWh <= 0 || total.BrakingWh <= 0 || total.PenaltyWh <= 0 {
+		t.Fatalf("got %+v, want positive aero, rolling, drivetrain, braking and penalty", total)
+	}
+
+	if len(perSegment) != len(segments) {
+		t.Fatalf("got %d segment rows, want %d", len(perSegment), len(segments))
+	}
+	var segBattery float64
+	for _, seg := range perSegment {
+		segBattery += seg.BatteryWh
+	}
+	if math.Abs(segBattery-total.BatteryWh) > 1e-6 {
+		t.Fatalf("got per-segment battery %.6f Wh, want total %.6f", segBattery, total.BatteryWh)
+	}
+}
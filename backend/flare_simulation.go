@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -15,12 +19,8 @@ func runSimulation() {
 	m := inputs.M
 	g := inputs.G
 	theta := inputs.Theta
-	rWheel := inputs.RWheel
-	Tmax := inputs.Tmax
-	Pmax := inputs.Pmax
 	batteryWh := inputs.BatteryWh
 	solarWhPerMin := inputs.SolarWhPerMin
-	etaDrive := inputs.EtaDrive
 	raceDayMin := inputs.RaceDayMin
 	gmax := inputs.Gmax
 	additionalEfficiency := inputs.AdditionalEfficiency
@@ -168,30 +168,22 @@ func runSimulation() {
 	loc, _ := time.LoadLocation("America/New_York")
 	now := time.Now().In(loc)
 
-	spanStart := time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, loc)
-	spanEnd := time.Date(now.Year(), now.Month(), now.Day(), 17, 0, 0, 0, loc)
+	site := defaultSolarSite() // 1 forecast day is enough for one race day
+	schedule := defaultRaceDaySchedule(now, loc)
 
-	totalEnergyGained, fullBatt, err := BuildEnergyWithBattery(
-		29.6516, -82.3248, // lat, lon
-		5.0, 0.0, // tilt, azimuth
-		"America/New_York", // timezone
-		1.0,                // forecastDays (1 day is enough for 8 hours)
-
-		4.0,  // panelArea (m²)
-		0.22, // panelEff
-		0.9,  // systemEff
-
-		time.Hour, // dt
-		batteryWh, // initialBatteryWh
-
-		&spanStart, // start time
-		&spanEnd,   // end time
-	)
+	day, err := simulateRaceDay(site, schedule, inputs, batteryWh)
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Total Energy Gained: ", totalEnergyGained, "Fullbatt after gaines: ", fullBatt)
+	fmt.Println("Pre-race charge: ", day.PreRaceChargeWh, "Race start battery: ", day.RaceStartBatteryWh)
+	fmt.Println("Race solar: ", day.RaceSolarWh, "Post-race charge: ", day.PostRaceChargeWh)
+	fmt.Println("Overnight battery: ", day.OvernightBatteryWh)
+
+	// the race window now starts from the charged pack and sees the forecast solar
+	fullBatt := day.RaceStartBatteryWh
+	solarWhPerMin = day.RaceSolarWhPerMin
+	raceDayMin = schedule.Race.minutes()
 
 	var battWithLosses float64 = fullBatt
 
@@ -209,23 +201,20 @@ func runSimulation() {
 	for n := 0; n < 7; n += 1 {
 		var lapLoss float64 = 0.0
 		var numLaps float64 = 0.0
-		bestV, bestD := 0.0, 0.0
 		//find best speed and distace (estimate)
-		for v := 2.0; v <= 40.0; v += 0.5 {
-			if d, ok := DistanceForSpeedEV(v, battWithLosses, solarWhPerMin, etaDrive, raceDayMin,
-				rWheel, Tmax, Pmax, m, g, Crr, rho, Cd, A, theta, additionalEfficiency); ok && d > bestD {
-				bestD, bestV = d, v
-			}
+		iteration := inputs
+		iteration.BatteryWh = battWithLosses
+		iteration.MinReserveWh, iteration.MinReservePct = inputs.reserveWh(), 0
+		iteration.SolarWhPerMin = solarWhPerMin
+		iteration.RaceDayMin = raceDayMin
+		search, err := optimalSpeedSearch(iteration, defaultSpeedSearchOptions())
+		if err != nil {
+			panic(err)
 		}
-
-		// refine around best
-		// This is a finer search in a narrow window around the previously found best speed
-		for v := math.Max(0.5, bestV-2.0); v <= bestV+2.0; v += 0.1 {
-			if d, ok := DistanceForSpeedEV(v, battWithLosses, solarWhPerMin, etaDrive, raceDayMin,
-				rWheel, Tmax, Pmax, m, g, Crr, rho, Cd, A, theta, additionalEfficiency); ok && d > bestD {
-				bestD, bestV = d, v
-				numLaps = d / getTotalLength(NCM_Motorsports_Park)
-			}
+		bestV, bestD := search.X, search.Value
+		numLaps = bestD / getTotalLength(NCM_Motorsports_Park)
+		if search.HitBound {
+			fmt.Println("warning: optimal speed is at the search bound")
 		}
 
 		fmt.Println("Distance: ", bestD)
@@ -242,9 +231,173 @@ func runSimulation() {
 		fmt.Println("Number of Laps: ", numLaps)
 		fmt.Println("-----------------")
 		// sets up next iteration battery (with losses)
-		battWithLosses = fullBatt - lapLoss*numLaps
+		battWithLosses = math.Max(0, fullBatt-lapLoss*numLaps)
 		WriteStepStatstoCSV(bestV, math.Round(bestD), battWithLosses)
 	}
 
 	fmt.Println("Total Length of Track: ", getTotalLength(NCM_Motorsports_Park))
 }
+
+// runMultiDaySimulation runs the default preset over consecutive race days
+// starting on date (today when empty) and prints the per-day strategy table.
+func runMultiDaySimulation(days int, date string) {
+	if days < 1 {
+		fmt.Fprintln(os.Stderr, "multiday mode needs -days 1 or more")
+		os.Exit(2)
+	}
+	inputs := defaultSimulationInputs()
+	site := defaultSolarSite()
+	loc, err := time.LoadLocation(site.Timezone)
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now().In(loc)
+	firstDay := now
+	if date != "" {
+		firstDay, err = time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := selectWeatherForDays(&site, firstDay, days, now); err != nil {
+		panic(err)
+	}
+
+	schedules, err := multiDaySchedules(firstDay, days, inputs.RaceDayMin)
+	if err != nil {
+		panic(err)
+	}
+	lapLength := getTotalLength(telemetryTrackFromSegments(defaultTrackSegments()))
+	results, err := simulateMultiDay(site, schedules, inputs, inputs.BatteryWh, lapLength)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, day := range results {
+		fmt.Printf("Day %d (%s): distance %.0f m, laps %.1f, v %.2f m/s, SOC start %.1f%% end %.1f%% overnight %.1f%%\n",
+			day.Day, day.Date, day.DistanceM, day.Laps, day.OptimalV, day.RaceStartSOC, day.RaceEndSOC, day.OvernightSOC)
+	}
+	fmt.Println("Total distance: ", results[len(results)-1].CumulativeDist)
+}
+
+// runCoastdownCalibration fits Crr and CdA to the coastdown log at path using
+// the default preset and prints the preset update.
+func runCoastdownCalibration(path string) {
+	if path == "" {
+		panic("coastdown mode needs -coastdown <file.csv>")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	runs, err := decodeCoastdownCSV(f)
+	if err != nil {
+		panic(err)
+	}
+	result, err := fitCoastdown(defaultSimulationInputs(), runs, 0, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Runs: %d (%d samples), directions %v\n", result.Runs, result.Samples, result.Directions)
+	fmt.Printf("Crr: %.5f (95%% CI %.5f .. %.5f)\n", result.Crr, result.CrrCI.Low, result.CrrCI.High)
+	fmt.Printf("CdA: %.4f m^2 (95%% CI %.4f .. %.4f)\n", result.CdA, result.CdACI.Low, result.CdACI.High)
+	fmt.Printf("Cd:  %.4f (95%% CI %.4f .. %.4f)\n", result.Cd, result.CdCI.Low, result.CdCI.High)
+	fmt.Printf("Grade: %.5f rad, RMSE %.2f N, R^2 %.4f\n", result.GradeRad, result.RMSEN, result.R2)
+	for _, note := range result.Notes {
+		fmt.Println("Note:", note)
+	}
+	update, err := json.MarshalIndent(result.PresetUpdate, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Preset update:")
+	fmt.Println(string(update))
+}
+
+// runTelemetryCalibration compares the recorded lap at path with the default
+// preset on the default track, fits the comma-separated fields and prints the
+// residuals. With presetOut set the calibrated preset is written there.
+func runTelemetryCalibration(path, fields, presetOut string) {
+	if path == "" {
+		panic("calibrate mode needs -log <file.csv>")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	samples, err := decodeRaceLogCSV(f)
+	if err != nil {
+		panic(err)
+	}
+	var fit []string
+	if fields != "" {
+		fit = strings.Split(fields, ",")
+	}
+	result, err := calibrateTelemetry(defaultSimulationInputs(), defaultTrackSegments(), samples, 0, fit)
+	if err != nil {
+		panic(err)
+	}
+
+	printResiduals := func(label string, res calibrationResiduals) {
+		fmt.Printf("%s: speed RMSE %.2f m/s (bias %+.2f), lap time %.1f s vs %.1f s logged, energy %.1f Wh vs %.1f Wh logged\n",
+			label, res.SpeedRMSE, res.SpeedBias, res.SimLapTimeS, res.LoggedLapTimeS, res.SimEnergyWh, res.LoggedEnergyWh)
+	}
+	fmt.Printf("Model cruise %.2f m/s, logged lap %.0f m, model lap %.0f m\n", result.V, result.LoggedLengthM, result.SimLengthM)
+	printResiduals("Before", result.Before)
+	if result.After != nil {
+		printResiduals("After", *result.After)
+		for _, field := range fit {
+			fmt.Printf("Fitted %s: %.5f\n", field, result.Fitted[field])
+		}
+	}
+	for _, note := range result.Notes {
+		fmt.Println("Note:", note)
+	}
+	if presetOut == "" {
+		return
+	}
+	preset := simulationPreset{ID: "calibrated", Label: "Calibrated from " + filepath.Base(path), Inputs: result.PresetUpdate}
+	data, err := json.MarshalIndent(preset, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(presetOut, data, 0o644); err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote calibrated preset to", presetOut)
+}
+
+// runCANDecode decodes the CAN log at logPath with the DBC at dbcPath onto
+// the default timeline and writes it to stdout as CSV.
+func runCANDecode(dbcPath, logPath string) {
+	if dbcPath == "" || logPath == "" {
+		panic("can mode needs -dbc <file.dbc> and -can-log <file>")
+	}
+	dbcFile, err := os.Open(dbcPath)
+	if err != nil {
+		panic(err)
+	}
+	defer dbcFile.Close()
+	db, err := parseDBC(dbcFile)
+	if err != nil {
+		panic(err)
+	}
+	text, err := os.ReadFile(logPath)
+	if err != nil {
+		panic(err)
+	}
+	frames, err := decodeCANLog(string(text), "")
+	if err != nil {
+		panic(err)
+	}
+	result, err := decodeCANTimeline(db, frames, canChannelMap{}, 0, defaultSimulationInputs().RWheel, 0)
+	if err != nil {
+		panic(err)
+	}
+	if err := writeCANTimelineCSV(os.Stdout, result); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	goalSeekScanPoints = 64
+	goalSeekBisections = 60
+	goalSeekTolerance  = 1e-6 // of max(|target|, 1); a bracket that ends further off is a jump, not a root
+)
+
+// goalSeekBounds are the search ranges for inputs with a physical range;
+// other fields default to baseline/100 .. baseline*100.
+var goalSeekBounds = map[string][2]float64{
+	"etaDrive":             {0.01, 1},
+	"additionalEfficiency": {-99, 100},
+	"theta":                {-0.3, 0.3},
+	"cRr":                  {0, 0.1},
+}
+
+// goalSeekResult is the input value that meets the target metric, or the
+// closest the range allows when the target is unreachable.
+type goalSeekResult struct {
+	Field      string  `json:"field"`
+	Metric     string  `json:"metric"` // "distance", "laps" or "remainingEnergy"
+	Target     float64 `json:"target"`
+	Reachable  bool    `json:"reachable"`
+	Value      float64 `json:"value"`    // solution, or the closest value when unreachable
+	Achieved   float64 `json:"achieved"` // metric at Value
+	OptimalV   float64 `json:"optimalV"` // cruise speed at Value
+	RangeMin   float64 `json:"rangeMin"`
+	RangeMax   float64 `json:"rangeMax"`
+	Iterations int     `json:"iterations"`
+}
+
+// goalSeekRange returns the search range for field around its baseline.
+func goalSeekRange(field string, baseline float64) (float64, float64) {
+	if b, ok := goalSeekBounds[field]; ok {
+		return b[0], b[1]
+	}
+	if baseline > 0 {
+		return baseline / 100, baseline * 100
+	}
+	return -100, 100
+}
+
+// goalSeekMetric evaluates metric for inputs. With fixedV > 0 the speed is
+// held there; otherwise the optimal speed is re-solved.
+func goalSeekMetric(inputs simulationInputs, metric string, fixedV, lapLengthM float64) (float64, float64, bool) {
+	if validateSimulationInputs(inputs) != nil {
+		return 0, 0, false
+	}
+	if fixedV > 0 {
+		inputs.V = fixedV
+	} else if inputs.V = computeOptimalSpeedForInputs(inputs); inputs.V <= 0 {
+		return 0, 0, false
+	}
+	distance, ok := distanceForInputs(inputs)
+	if !ok {
+		return 0, 0, false
+	}
+	switch metric {
+	case "distance":
+		return distance, inputs.V, true
+	case "laps":
+		return distance / lapLengthM, inputs.V, true
+	default:
+		return remainingEnergyForInputs(inputs), inputs.V, true
+	}
+}
+
+// goalSeek solves for the value of field that makes metric equal target. It
+// scans the range for a sign change of metric - target and bisects the
+// first one it finds, so it also copes with metrics that are not monotonic
+// in the field. fixedV > 0 holds the cruise speed instead of re-optimising
+// it, which is what a remaining-energy target needs: at the optimal speed
+// the pack is, by construction, empty at the flag.
+func goalSeek(base simulationInputs, field, metric string, target, lo, hi, fixedV float64) (goalSeekResult, error) {
+	baseline, err := inputField(base, field)
+	if err != nil {
+		return goalSeekResult{}, err
+	}
+	if metric != "distance" && metric != "laps" && metric != "remainingEnergy" {
+		return goalSeekResult{}, fmt.Errorf("unknown metric %q (want distance, laps or remainingEnergy)", metric)
+	}
+	if lo == 0 && hi == 0 {
+		lo, hi = goalSeekRange(field, baseline)
+	}
+	if !(hi > lo) {
+		return goalSeekResult{}, fmt.Errorf("search range needs min < max")
+	}
+	lapLengthM := getTotalLength(telemetryTrackFromSegments(defaultTrackSegments()))
+
+	result := goalSeekResult{Field: field, Metric: metric, Target: target, RangeMin: lo, RangeMax: hi}
+	eval := func(x float64) (float64, float64, bool) {
+		inputs := base
+		_ = setInputField(&inputs, field, x)
+		result.Iterations++
+		return goalSeekMetric(inputs, metric, fixedV, lapLengthM)
+	}
+
+	// A re-optimised speed is only resolved to defaultSpeedSearchTol, so the
+	// metric moves in steps of about that share of the speed; no bracket can
+	// land closer than that.
+	speedTol := 0.0
+	if fixedV <= 0 {
+		speedTol = defaultSpeedSearchTol
+	}
+	if !seekGoal(eval, target, lo, hi, speedTol, &result) {
+		return result, fmt.Errorf("no value of %s in [%g, %g] is feasible for the model", field, lo, hi)
+	}
+	return result, nil
+}
+
+// seekGoal scans [lo, hi] for a sign change of eval - target and bisects
+// each bracket until one meets the target, filling in result. When none does,
+// result holds the closest feasible point and Reachable stays false. It
+// returns false when no evaluated point was feasible. speedTol is the
+// resolution of the speed eval reports, or 0 when the speed is exact; the
+// target tolerance widens to speedTol/v of it.
+func seekGoal(eval func(float64) (float64, float64, bool), target, lo, hi, speedTol float64, result *goalSeekResult) bool {
+	closest := math.Inf(1)
+	// keep records the closest feasible point seen so far, scanned or bisected
+	keep := func(x, achieved, v float64) {
+		if gap := math.Abs(achieved - target); gap < closest {
+			closest = gap
+			result.Value, result.Achieved, result.OptimalV = x, achieved, v
+		}
+	}
+	tol := func(v float64) float64 {
+		rel := goalSeekTolerance
+		if speedTol > 0 && v > 0 {
+			rel = math.Max(rel, speedTol/v)
+		}
+		return rel * math.Max(math.Abs(target), 1)
+	}
+	prevX, prevGap, havePrev := 0.0, 0.0, false
+	for i := 0; i < goalSeekScanPoints; i++ {
+		x := lo + (hi-lo)*float64(i)/float64(goalSeekScanPoints-1)
+		achieved, v, ok := eval(x)
+		if !ok {
+			havePrev = false
+			continue
+		}
+		keep(x, achieved, v)
+		gap := achieved - target
+		if gap == 0 {
+			result.Reachable = true
+			return true
+		}
+		if havePrev && (gap > 0) != (prevGap > 0) {
+			a, b, gapA := prevX, x, prevGap
+			for j := 0; j < goalSeekBisections; j++ {
+				mid := 0.5 * (a + b)
+				achieved, v, ok := eval(mid)
+				if !ok {
+					break
+				}
+				keep(mid, achieved, v)
+				if (achieved-target > 0) == (gapA > 0) {
+					a, gapA = mid, achieved-target
+				} else {
+					b = mid
+				}
+			}
+			if closest <= tol(result.OptimalV) {
+				result.Reachable = true
+				return true
+			}
+			// the bracket hit an infeasible midpoint or a jump; keep scanning
+		}
+		prevX, prevGap, havePrev = x, gap, true
+	}
+	return !math.IsInf(closest, 1)
+}
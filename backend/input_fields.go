@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// inputFieldIndex maps each client-settable simulationInputs JSON name to its
+// struct field index, so studies can vary inputs by the names the frontend
+// already sends.
+var inputFieldIndex = func() map[string]int {
+	fields := map[string]int{}
+	t := reflect.TypeOf(simulationInputs{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" || f.Type.Kind() != reflect.Float64 {
+			continue
+		}
+		fields[name] = i
+	}
+	return fields
+}()
+
+// inputFieldNames lists the names accepted by setInputField, sorted.
+func inputFieldNames() []string {
+	names := make([]string, 0, len(inputFieldIndex))
+	for name := range inputFieldIndex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unknownInputField(name string) error {
+	return fmt.Errorf("unknown input field %q (want one of %s)", name, strings.Join(inputFieldNames(), ", "))
+}
+
+// inputField returns the value of the simulationInputs field with JSON name.
+func inputField(inputs simulationInputs, name string) (float64, error) {
+	i, ok := inputFieldIndex[name]
+	if !ok {
+		return 0, unknownInputField(name)
+	}
+	return reflect.ValueOf(inputs).Field(i).Float(), nil
+}
+
+// setInputField sets the simulationInputs field with JSON name to value.
+func setInputField(inputs *simulationInputs, name string, value float64) error {
+	i, ok := inputFieldIndex[name]
+	if !ok {
+		return unknownInputField(name)
+	}
+	reflect.ValueOf(inputs).Elem().Field(i).SetFloat(value)
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const defaultMinLapS = 30.0 // crossings closer than this to the last one are GPS jitter on the line
+
+// trackGate is a line across the track between two GPS points, such as the
+// start/finish line or a sector boundary.
+type trackGate struct {
+	Lat1 float64 `json:"lat1"`
+	Lon1 float64 `json:"lon1"`
+	Lat2 float64 `json:"lat2"`
+	Lon2 float64 `json:"lon2"`
+}
+
+func (g trackGate) validate() error {
+	if g.Lat1 == g.Lat2 && g.Lon1 == g.Lon2 {
+		return fmt.Errorf("gate needs two distinct points")
+	}
+	return nil
+}
+
+// gpsLap is one complete lap between start/finish crossings.
+type gpsLap struct {
+	Lap       int       `json:"lap"`
+	StartS    float64   `json:"startS"` // log time at the line
+	EndS      float64   `json:"endS"`
+	LapTimeS  float64   `json:"lapTimeS"`
+	DistanceM float64   `json:"distanceM"`         // GPS path length
+	SectorS   []float64 `json:"sectorS,omitempty"` // split times; nil when a sector gate was missed
+}
+
+// gpsFix maps one log sample onto the track. Lap is 0 for fixes outside a
+// complete lap (out-lap, in-lap); their distances are 0.
+type gpsFix struct {
+	T              float64 `json:"t"`
+	Lap            int     `json:"lap"`
+	LapDistanceM   float64 `json:"lapDistanceM"`   // GPS path since the line
+	TrackDistanceM float64 `json:"trackDistanceM"` // scaled to the model lap, comparable with telemetryPoint.Distance
+}
+
+// lapDetectionResult is a GPS log cut into laps.
+type lapDetectionResult struct {
+	TrackLengthM float64  `json:"trackLengthM"`
+	Laps         []gpsLap `json:"laps"`
+	BestLap      int      `json:"bestLap"` // 0 when no lap was completed
+	Fixes        []gpsFix `json:"fixes"`
+	Notes        []string `json:"notes,omitempty"`
+}
+
+// localXY projects a fix to metres east and north of (lat0, lon0). The
+// equirectangular approximation is well under a centimetre across a track.
+func localXY(lat, lon, lat0, lon0 float64) (float64, float64) {
+	const rad = math.Pi / 180
+	return (lon - lon0) * rad * earthRadiusM * math.Cos(lat0*rad), (lat - lat0) * rad * earthRadiusM
+}
+
+// segmentCrossing returns where along p1→p2 (0..1) it crosses q1→q2.
+func segmentCrossing(p1x, p1y, p2x, p2y, q1x, q1y, q2x, q2y float64) (float64, bool) {
+	rx, ry := p2x-p1x, p2y-p1y
+	sx, sy := q2x-q1x, q2y-q1y
+	denom := rx*sy - ry*sx
+	if denom == 0 {
+		return 0, false
+	}
+	qpx, qpy := q1x-p1x, q1y-p1y
+	t := (qpx*sy - qpy*sx) / denom
+	u := (qpx*ry - qpy*rx) / denom
+	if t < 0 || t >= 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
+
+// gateCrossing is when and how far along the log path a gate was crossed.
+type gateCrossing struct {
+	T, PathM float64
+}
+
+// detectLaps finds start/finish crossings of gate in a GPS log, splits it
+// into laps and times the sectors between the sector gates (in track
+// order). Each fix inside a complete lap gets its distance along the lap,
+// scaled so the lap is trackLengthM long; that is the distance axis of the
+// simulated telemetry, so recorded and simulated laps can be lined up.
+func detectLaps(samples []raceLogSample, gate trackGate, sectors []trackGate, minLapS, trackLengthM float64) (lapDetectionResult, error) {
+	if err := validateRaceLog(samples); err != nil {
+		return lapDetectionResult{}, err
+	}
+	for i, s := range samples {
+		if s.Lat == 0 && s.Lon == 0 {
+			return lapDetectionResult{}, fmt.Errorf("telemetry log sample %d has no GPS fix", i+1)
+		}
+	}
+	if err := gate.validate(); err != nil {
+		return lapDetectionResult{}, err
+	}
+	for i, g := range sectors {
+		if err := g.validate(); err != nil {
+			return lapDetectionResult{}, fmt.Errorf("sector %d: %w", i+1, err)
+		}
+	}
+	if minLapS <= 0 {
+		minLapS = defaultMinLapS
+	}
+	if trackLengthM <= 0 {
+		return lapDetectionResult{}, fmt.Errorf("track length must be positive")
+	}
+
+	lat0, lon0 := 0.5*(gate.Lat1+gate.Lat2), 0.5*(gate.Lon1+gate.Lon2)
+	xs, ys := make([]float64, len(samples)), make([]float64, len(samples))
+	for i, s := range samples {
+		xs[i], ys[i] = localXY(s.Lat, s.Lon, lat0, lon0)
+	}
+	path := logDistances(samples)
+	crossings := func(g trackGate) []gateCrossing {
+		g1x, g1y := localXY(g.Lat1, g.Lon1, lat0, lon0)
+		g2x, g2y := localXY(g.Lat2, g.Lon2, lat0, lon0)
+		var out []gateCrossing
+		for i := 1; i < len(samples); i++ {
+			frac, ok := segmentCrossing(xs[i-1], ys[i-1], xs[i], ys[i], g1x, g1y, g2x, g2y)
+			if !ok {
+				continue
+			}
+			out = append(out, gateCrossing{
+				T:     samples[i-1].T + frac*(samples[i].T-samples[i-1].T),
+				PathM: path[i-1] + frac*(path[i]-path[i-1]),
+			})
+		}
+		return out
+	}
+
+	var lines []gateCrossing
+	for _, c := range crossings(gate) {
+		if n := len(lines); n == 0 || c.T-lines[n-1].T >= minLapS {
+			lines = append(lines, c)
+		}
+	}
+	sectorCrossings := make([][]gateCrossing, len(sectors))
+	for i, g := range sectors {
+		sectorCrossings[i] = crossings(g)
+	}
+
+	result := lapDetectionResult{TrackLengthM: trackLengthM}
+	missedSector := false
+	for k := 1; k < len(lines); k++ {
+		start, end := lines[k-1], lines[k]
+		lap := gpsLap{Lap: k, StartS: start.T, EndS: end.T, LapTimeS: end.T - start.T, DistanceM: end.PathM - start.PathM}
+		if len(sectors) > 0 {
+			splits, prev := make([]float64, 0, len(sectors)+1), start.T
+			for _, candidates := range sectorCrossings {
+				next := math.NaN()
+				for _, c := range candidates {
+					if c.T > prev && c.T < end.T {
+						next = c.T
+						break
+					}
+				}
+				if math.IsNaN(next) {
+					splits = nil
+					break
+				}
+				splits = append(splits, next-prev)
+				prev = next
+			}
+			if splits != nil {
+				lap.SectorS = append(splits, end.T-prev)
+			} else {
+				missedSector = true
+			}
+		}
+		result.Laps = append(result.Laps, lap)
+		if result.BestLap == 0 || lap.LapTimeS < result.Laps[result.BestLap-1].LapTimeS {
+			result.BestLap = k
+		}
+	}
+
+	result.Fixes = make([]gpsFix, len(samples))
+	k := 0
+	for i, s := range samples {
+		fix := gpsFix{T: s.T}
+		for k < len(result.Laps) && s.T >= result.Laps[k].EndS {
+			k++
+		}
+		if k < len(result.Laps) && s.T >= result.Laps[k].StartS {
+			lap := result.Laps[k]
+			fix.Lap = lap.Lap
+			fix.LapDistanceM = path[i] - lines[k].PathM
+			if lap.DistanceM > 0 {
+				fix.TrackDistanceM = fix.LapDistanceM / lap.DistanceM * trackLengthM
+			}
+		}
+		result.Fixes[i] = fix
+	}
+
+	if len(lines) < 2 {
+		result.Notes = append(result.Notes, fmt.Sprintf("found %d start/finish crossing(s); no complete lap", len(lines)))
+	}
+	if missedSector {
+		result.Notes = append(result.Notes, "a sector gate was not crossed on some laps; their sector times are omitted")
+	}
+	return result, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testCircleLat = 36.0
+	testCircleLon = -86.0
+	testCircleR   = 200.0 // m
+	testCircleV   = 20.0  // m/s
+)
+
+// circleFix is the position at angle (radians, counter-clockwise from east)
+// on a circular test track.
+func circleFix(angle float64) (float64, float64) {
+	const deg = 180 / math.Pi
+	lat := testCircleLat + testCircleR*math.Sin(angle)/earthRadiusM*deg
+	lon := testCircleLon + testCircleR*math.Cos(angle)/(earthRadiusM*math.Cos(testCircleLat/deg))*deg
+	return lat, lon
+}
+
+// circleLog drives the circle at constant speed from startAngle for
+// durationS, logging at 1 Hz.
+func circleLog(startAngle, durationS float64) []raceLogSample {
+	var samples []raceLogSample
+	for t := 0.0; t <= durationS; t++ {
+		lat, lon := circleFix(startAngle + testCircleV*t/testCircleR)
+		samples = append(samples, raceLogSample{T: t, Lat: lat, Lon: lon, Speed: testCircleV})
+	}
+	return samples
+}
+
+// radialGate crosses the circle at angle.
+func radialGate(angle float64) trackGate {
+	lat1, lon1 := circleFix(angle)
+	lat2, lon2 := lat1+(lat1-testCircleLat)*0.1, lon1+(lon1-testCircleLon)*0.1
+	lat1, lon1 = lat1-(lat1-testCircleLat)*0.1, lon1-(lon1-testCircleLon)*0.1
+	return trackGate{Lat1: lat1, Lon1: lon1, Lat2: lat2, Lon2: lon2}
+}
+
+func TestDetectLapsTimesLapsAndSectors(t *testing.T) {
+	lapTime := 2 * math.Pi * testCircleR / testCircleV
+	samples := circleLog(-0.3, 3.5*lapTime)
+
+	got, err := detectLaps(samples, radialGate(0), []trackGate{radialGate(math.Pi)}, 0, 1000)
+	if err != nil {
+		t.Fatalf("detectLaps returned error: %v", err)
+	}
+	if len(got.Laps) != 3 {
+		t.Fatalf("got %d laps, want 3", len(got.Laps))
+	}
+	for _, lap := range got.Laps {
+		if math.Abs(lap.LapTimeS-lapTime) > 0.05 {
+			t.Fatalf("lap %d: got %.3f s, want %.3f s", lap.Lap, lap.LapTimeS, lapTime)
+		}
+		if len(lap.SectorS) != 2 || math.Abs(lap.SectorS[0]-lapTime/2) > 0.05 {
+			t.Fatalf("lap %d: got sectors %v, want two halves of %.3f s", lap.Lap, lap.SectorS, lapTime)
+		}
+	}
+	if got.Laps[0].StartS < 2.9 || got.Laps[0].StartS > 3.1 {
+		t.Fatalf("got first crossing at %.3f s, want 3 s", got.Laps[0].StartS)
+	}
+}
+
+func TestDetectLapsMapsFixesToTrackDistance(t *testing.T) {
+	lapTime := 2 * math.Pi * testCircleR / testCircleV
+	samples := circleLog(-0.3, 2.5*lapTime)
+
+	got, err := detectLaps(samples, radialGate(0), nil, 0, 1000)
+	if err != nil {
+		t.Fatalf("detectLaps returned error: %v", err)
+	}
+	if got.Fixes[0].Lap != 0 || got.Fixes[len(got.Fixes)-1].Lap != 0 {
+		t.Fatalf("got out-lap %d and in-lap %d, want both outside a complete lap", got.Fixes[0].Lap, got.Fixes[len(got.Fixes)-1].Lap)
+	}
+	// half way round the first lap is half the model track
+	mid := int(math.Round(3 + lapTime/2))
+	if fix := got.Fixes[mid]; fix.Lap != 1 || math.Abs(fix.TrackDistanceM-500) > 10 {
+		t.Fatalf("got fix %+v, want lap 1 at about 500 m", fix)
+	}
+}
+
+func TestDetectLapsIgnoresJitterOnTheLine(t *testing.T) {
+	lat, lon := circleFix(-0.001)
+	latB, lonB := circleFix(0.001)
+	samples := []raceLogSample{
+		{T: 0, Lat: lat, Lon: lon}, {T: 1, Lat: latB, Lon: lonB},
+		{T: 2, Lat: lat, Lon: lon}, {T: 3, Lat: latB, Lon: lonB},
+	}
+	got, err := detectLaps(samples, radialGate(0), nil, 0, 1000)
+	if err != nil {
+		t.Fatalf("detectLaps returned error: %v", err)
+	}
+	if len(got.Laps) != 0 || len(got.Notes) == 0 {
+		t.Fatalf("got %d laps notes %v, want no lap from jitter", len(got.Laps), got.Notes)
+	}
+}
+
+func TestLapDetectionHandlerDefaultsTrackLength(t *testing.T) {
+	lapTime := 2 * math.Pi * testCircleR / testCircleV
+	body, err := json.Marshal(lapDetectionRequest{Samples: circleLog(-0.3, 2.5*lapTime), Gate: radialGate(0)})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/laps/detect", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	lapDetectionHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got lapDetectionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	want := getTotalLength(telemetryTrackFromSegments(defaultTrackSegments()))
+	if !got.OK || got.TrackLengthM != want || len(got.Laps) != 2 || got.BestLap == 0 {
+		t.Fatalf("got ok=%v track %.1f m with %d laps, want %.1f m and 2 laps", got.OK, got.TrackLengthM, len(got.Laps), want)
+	}
+}
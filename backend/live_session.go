@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	maxLiveSessions       = 64
+	maxLiveSessionSamples = 200000 // about 55 hours at 1 Hz
+)
+
+// liveSample is one reading streamed from the car. T is seconds since the
+// race start; SOC (%) is used for the battery when the car reports it,
+// otherwise the battery is counted down from the pack voltage and current.
+// DistanceM shadows the log sample's field so a reported 0 m at the line is
+// told apart from no distance at all.
+type liveSample struct {
+	raceLogSample
+	DistanceM *float64 `json:"distanceM,omitempty"`
+	SOC       *float64 `json:"soc,omitempty"`
+}
+
+// livePlan is the /simulate plan the session is compared against.
+type livePlan struct {
+	Inputs            simulationInputs `json:"inputs"`
+	OptimalV          float64          `json:"optimalV"`
+	LapLengthM        float64          `json:"lapLengthM"`
+	LapTimeS          float64          `json:"lapTimeS"`
+	LapEnergyWh       float64          `json:"lapEnergyWh"`
+	LapSolarWh        float64          `json:"lapSolarWh"`
+	Laps              float64          `json:"laps"` // including the last partial lap
+	DistanceM         float64          `json:"distanceM"`
+	RemainingEnergyWh float64          `json:"remainingEnergyWh"`
+	LimitedBy         string           `json:"limitedBy"`
+}
+
+// at returns the planned laps and battery [Wh] at elapsedS into the race.
+// The plan holds its end state once its laps are done.
+func (p livePlan) at(elapsedS float64) (float64, float64) {
+	t := math.Min(math.Max(elapsedS, 0), p.Laps*p.LapTimeS)
+	netWhPerS := (p.LapEnergyWh - p.LapSolarWh) / p.LapTimeS
+	return t / p.LapTimeS, math.Min(p.Inputs.BatteryWh, p.Inputs.BatteryWh-netWhPerS*t)
+}
+
+// liveDelta is where the car is against the plan at the latest sample.
+// Positive deltas mean ahead of the plan: more laps done or more energy left.
+type liveDelta struct {
+	ElapsedS         float64 `json:"elapsedS"`
+	Samples          int     `json:"samples"`
+	Speed            float64 `json:"speed"` // m/s, latest
+	PlanV            float64 `json:"planV"`
+	DistanceM        float64 `json:"distanceM"`
+	Laps             float64 `json:"laps"`
+	PlannedLaps      float64 `json:"plannedLaps"`
+	LapsDelta        float64 `json:"lapsDelta"`
+	BatteryWh        float64 `json:"batteryWh"`
+	SOC              float64 `json:"soc"`
+	PlannedBatteryWh float64 `json:"plannedBatteryWh"`
+	EnergyDeltaWh    float64 `json:"energyDeltaWh"`
+	// ProjectedLaps finishes the race from the current state at the plan's
+	// lap time and lap energy, whichever of time or battery runs out first.
+	ProjectedLaps float64 `json:"projectedLaps"`
+	PlanLaps      float64 `json:"planLaps"`
+}
+
+// liveSession is one race day's stream of samples and the plan it is
+// compared with.
+type liveSession struct {
+	mu        sync.Mutex
+	ID        string
+	CreatedAt time.Time
+	Plan      livePlan
+	samples   []liveSample
+	distanceM float64 // cumulative over samples
+	netWh     float64 // signed battery energy drawn over samples
+	dash      *dashBroadcast
+}
+
+// buildLivePlan runs the /simulate model for inputs: optimal cruise speed,
+// then the simulated lap repeated over the race window.
+func buildLivePlan(inputs simulationInputs) (livePlan, error) {
+	search, err := optimalSpeedSearch(inputs, defaultSpeedSearchOptions())
+	if err != nil {
+		return livePlan{}, fmt.Errorf("inputs are not feasible for the model")
+	}
+	inputs.V = search.X
+	points, err := buildTelemetryForInputs(defaultTrackSegments(), true, inputs)
+	if err != nil {
+		return livePlan{}, err
+	}
+	race, err := trackRaceDistance(points, inputs)
+	if err != nil {
+		return livePlan{}, err
+	}
+	return livePlan{
+		Inputs:            inputs,
+		OptimalV:          inputs.V,
+		LapLengthM:        race.LapLengthM,
+		LapTimeS:          race.LapTimeS,
+		LapEnergyWh:       race.LapEnergyWh,
+		LapSolarWh:        race.LapSolarWh,
+		Laps:              race.DistanceM / race.LapLengthM,
+		DistanceM:         race.DistanceM,
+		RemainingEnergyWh: race.RemainingEnergyWh,
+		LimitedBy:         race.LimitedBy,
+	}, nil
+}
+
+// addSamples appends samples, which must continue in time order from the
+// ones already stored. Distance is the reported track distance when the
+// sample has one, else the GPS path, else integrated speed.
+func (s *liveSession) addSamples(samples []liveSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples)+len(samples) > maxLiveSessionSamples {
+		return fmt.Errorf("session is full (%d samples)", maxLiveSessionSamples)
+	}
+	prevT := math.Inf(-1)
+	if n := len(s.samples); n > 0 {
+		prevT = s.samples[n-1].T
+	}
+	for i, sample := range samples {
+		if sample.T <= prevT {
+			return fmt.Errorf("sample %d: time %g s is not after the previous sample", i+1, sample.T)
+		}
+		prevT = sample.T
+	}
+	for _, sample := range samples {
+		n := len(s.samples)
+		if sample.DistanceM != nil {
+			s.distanceM = *sample.DistanceM
+		}
+		if n > 0 {
+			prev := s.samples[n-1]
+			switch {
+			case sample.DistanceM != nil:
+			case (prev.Lat != 0 || prev.Lon != 0) && (sample.Lat != 0 || sample.Lon != 0):
+				s.distanceM += gpsDistanceM(prev.Lat, prev.Lon, sample.Lat, sample.Lon)
+			default:
+				s.distanceM += 0.5 * (prev.Speed + sample.Speed) * (sample.T - prev.T)
+			}
+			s.netWh += 0.5 * (prev.batteryPowerW() + sample.batteryPowerW()) * (sample.T - prev.T) / 3600.0
+		}
+		s.samples = append(s.samples, sample)
+	}
+	return nil
+}
+
+// delta compares the latest sample with the plan.
+func (s *liveSession) delta() liveDelta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan := s.Plan
+	inputs := plan.Inputs
+	d := liveDelta{Samples: len(s.samples), PlanV: plan.OptimalV, PlanLaps: plan.Laps, BatteryWh: inputs.BatteryWh}
+	if len(s.samples) > 0 {
+		last := s.samples[len(s.samples)-1]
+		d.ElapsedS, d.Speed = last.T, last.Speed
+		d.BatteryWh = math.Min(inputs.BatteryWh, inputs.BatteryWh-s.netWh)
+		if last.SOC != nil {
+			d.BatteryWh = *last.SOC / 100 * inputs.BatteryWh
+		}
+	}
+	d.DistanceM = s.distanceM
+	d.Laps = s.distanceM / plan.LapLengthM
+	d.SOC = d.BatteryWh / inputs.BatteryWh * 100
+	d.PlannedLaps, d.PlannedBatteryWh = plan.at(d.ElapsedS)
+	d.LapsDelta = d.Laps - d.PlannedLaps
+	d.EnergyDeltaWh = d.BatteryWh - d.PlannedBatteryWh
+
+	remainingS := math.Max(inputs.RaceDayMin*60-d.ElapsedS, 0)
+	lapsLeft := remainingS / plan.LapTimeS
+	if net := plan.LapEnergyWh - plan.LapSolarWh; net > 0 {
+		lapsLeft = math.Min(lapsLeft, math.Max(d.BatteryWh-inputs.reserveWh(), 0)/net)
+	}
+	d.ProjectedLaps = d.Laps + lapsLeft
+	return d
+}
+
+// setDash replaces the session's dash broadcast, closing the old one; nil
+// stops broadcasting.
+func (s *liveSession) setDash(b *dashBroadcast) {
+	s.mu.Lock()
+	old := s.dash
+	s.dash = b
+	s.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+// broadcast publishes the latest position to the dash, if one is set.
+func (s *liveSession) broadcast() (*dashMessage, error) {
+	s.mu.Lock()
+	b := s.dash
+	s.mu.Unlock()
+	if b == nil {
+		return nil, nil
+	}
+	d := s.delta()
+	msg, err := b.send(b.Plan.message(d.ElapsedS, d.DistanceM, d.Speed))
+	return &msg, err
+}
+
+// liveSessionStore keeps the sessions in memory for the life of the server.
+type liveSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*liveSession
+}
+
+var liveSessions = &liveSessionStore{sessions: map[string]*liveSession{}}
+
+func (st *liveSessionStore) create(plan livePlan) (*liveSession, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.sessions) >= maxLiveSessions {
+		return nil, fmt.Errorf("too many live sessions (max %d)", maxLiveSessions)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	session := &liveSession{ID: hex.EncodeToString(id[:]), CreatedAt: time.Now(), Plan: plan}
+	st.sessions[session.ID] = session
+	return session, nil
+}
+
+func (st *liveSessionStore) get(id string) (*liveSession, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	session, ok := st.sessions[id]
+	return session, ok
+}
+
+func (st *liveSessionStore) remove(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	session, ok := st.sessions[id]
+	delete(st.sessions, id)
+	if ok {
+		session.setDash(nil)
+	}
+	return ok
+}
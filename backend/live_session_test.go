@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestLiveSession(t *testing.T) liveSessionResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/live/sessions", bytes.NewReader([]byte(`{}`)))
+	rec := httptest.NewRecorder()
+
+	liveSessionsHandler(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var got liveSessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	t.Cleanup(func() { liveSessions.remove(got.ID) })
+	return got
+}
+
+func postLiveSamples(t *testing.T, id string, samples []liveSample) (int, liveSessionResponse) {
+	t.Helper()
+	body, err := json.Marshal(liveSamplesRequest{Samples: samples})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/live/sessions/"+id+"/samples", bytes.NewReader(body))
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+
+	liveSamplesHandler(rec, req)
+
+	var got liveSessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	return rec.Code, got
+}
+
+// onPlanSamples follows the plan exactly: plan distance, and a pack at 100 V
+// drawing the plan's net power.
+func onPlanSamples(plan livePlan, fromS, toS, stepS float64) []liveSample {
+	netW := (plan.LapEnergyWh - plan.LapSolarWh) / plan.LapTimeS * 3600
+	var samples []liveSample
+	for ts := fromS; ts <= toS; ts += stepS {
+		s := liveSample{raceLogSample: raceLogSample{T: ts, Speed: plan.OptimalV, VoltageV: 100, CurrentA: netW / 100}}
+		distance := ts / plan.LapTimeS * plan.LapLengthM
+		s.DistanceM = &distance
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func TestLiveSessionOnPlanHasNoDelta(t *testing.T) {
+	session := newTestLiveSession(t)
+	if session.Plan == nil || session.Plan.LapTimeS <= 0 {
+		t.Fatalf("got plan %+v, want the simulated lap", session.Plan)
+	}
+
+	code, got := postLiveSamples(t, session.ID, onPlanSamples(*session.Plan, 0, 1800, 1))
+	if code != http.StatusOK || !got.OK {
+		t.Fatalf("got status %d ok=%v: %s", code, got.OK, got.Message)
+	}
+	if got.Delta.ElapsedS != 1800 || math.Abs(got.Delta.LapsDelta) > 1e-6 || math.Abs(got.Delta.EnergyDeltaWh) > 1e-3 {
+		t.Fatalf("got delta %+v, want on plan at 1800 s", got.Delta)
+	}
+	if math.Abs(got.Delta.ProjectedLaps-session.Plan.Laps) > 1e-3 {
+		t.Fatalf("got projected %.4f laps, want the plan's %.4f", got.Delta.ProjectedLaps, session.Plan.Laps)
+	}
+}
+
+func TestLiveSessionBehindOnLapsAheadOnEnergy(t *testing.T) {
+	session := newTestLiveSession(t)
+	plan := *session.Plan
+
+	samples := onPlanSamples(plan, 0, 600, 1)
+	for i := range samples {
+		samples[i].DistanceM = nil // distance from integrated speed instead
+		samples[i].Speed = 0.9 * plan.OptimalV
+	}
+	soc := 99.0
+	samples[len(samples)-1].SOC = &soc
+	_, got := postLiveSamples(t, session.ID, samples)
+
+	if got.Delta.LapsDelta >= 0 {
+		t.Fatalf("got laps delta %.4f, want behind the plan", got.Delta.LapsDelta)
+	}
+	if got.Delta.BatteryWh != 0.99*plan.Inputs.BatteryWh || got.Delta.EnergyDeltaWh <= 0 {
+		t.Fatalf("got battery %.1f Wh delta %.1f Wh, want the reported SOC ahead of the plan", got.Delta.BatteryWh, got.Delta.EnergyDeltaWh)
+	}
+}
+
+func TestLiveSessionUsesReportedZeros(t *testing.T) {
+	session := newTestLiveSession(t)
+
+	samples := onPlanSamples(*session.Plan, 0, 60, 1)
+	zero := 0.0
+	for i := range samples {
+		samples[i].DistanceM = &zero // parked on the line with a flat pack
+		samples[i].SOC = &zero
+	}
+	_, got := postLiveSamples(t, session.ID, samples)
+
+	if got.Delta.DistanceM != 0 || got.Delta.BatteryWh != 0 {
+		t.Fatalf("got %.1f m and %.1f Wh, want the reported 0 m and empty pack", got.Delta.DistanceM, got.Delta.BatteryWh)
+	}
+}
+
+func TestLiveSessionRejectsOutOfOrderSamples(t *testing.T) {
+	session := newTestLiveSession(t)
+	if code, _ := postLiveSamples(t, session.ID, onPlanSamples(*session.Plan, 10, 20, 1)); code != http.StatusOK {
+		t.Fatalf("got status %d for the first batch, want %d", code, http.StatusOK)
+	}
+
+	code, got := postLiveSamples(t, session.ID, onPlanSamples(*session.Plan, 5, 6, 1))
+	if code != http.StatusBadRequest || got.Delta.Samples != 11 {
+		t.Fatalf("got status %d with %d samples kept, want %d and 11", code, got.Delta.Samples, http.StatusBadRequest)
+	}
+}
+
+func TestLiveSessionHandlerGetAndDelete(t *testing.T) {
+	session := newTestLiveSession(t)
+
+	for _, tc := range []struct {
+		method string
+		want   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodDelete, http.StatusOK},
+		{http.MethodGet, http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tc.method, "/live/sessions/"+session.ID, nil)
+		req.SetPathValue("id", session.ID)
+		rec := httptest.NewRecorder()
+
+		liveSessionHandler(rec, req)
+
+		if rec.Code != tc.want {
+			t.Fatalf("%s got status %d, want %d", tc.method, rec.Code, tc.want)
+		}
+	}
+}
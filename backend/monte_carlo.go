@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultMonteCarloSamples = 1000
+	maxMonteCarloSamples     = 100000
+)
+
+var defaultMonteCarloPercentiles = []float64{10, 50, 90}
+
+// inputDistribution is the uncertainty on one simulationInputs field.
+//
+//	normal:     Mean (default: the base value), StdDev
+//	uniform:    Min, Max
+//	triangular: Min, Mode, Max
+type inputDistribution struct {
+	Kind   string   `json:"kind"`
+	Mean   *float64 `json:"mean,omitempty"`
+	StdDev float64  `json:"stdDev,omitempty"`
+	Min    float64  `json:"min,omitempty"`
+	Max    float64  `json:"max,omitempty"`
+	Mode   float64  `json:"mode,omitempty"`
+}
+
+func (d inputDistribution) validate() error {
+	switch d.Kind {
+	case "normal":
+		if d.StdDev < 0 {
+			return fmt.Errorf("normal distribution needs stdDev >= 0")
+		}
+	case "uniform":
+		if d.Max < d.Min {
+			return fmt.Errorf("uniform distribution needs min <= max")
+		}
+	case "triangular":
+		if d.Mode < d.Min || d.Max < d.Mode {
+			return fmt.Errorf("triangular distribution needs min <= mode <= max")
+		}
+	default:
+		return fmt.Errorf("unknown distribution kind %q (want normal, uniform or triangular)", d.Kind)
+	}
+	return nil
+}
+
+// sample draws one value. A normal distribution needs Mean set; see
+// runMonteCarlo.
+func (d inputDistribution) sample(rng *rand.Rand) float64 {
+	switch d.Kind {
+	case "normal":
+		return *d.Mean + d.StdDev*rng.NormFloat64()
+	case "uniform":
+		return d.Min + (d.Max-d.Min)*rng.Float64()
+	default: // triangular, by inverting the CDF
+		u := rng.Float64()
+		span := d.Max - d.Min
+		if span == 0 {
+			return d.Min
+		}
+		split := (d.Mode - d.Min) / span
+		if u < split {
+			return d.Min + math.Sqrt(u*span*(d.Mode-d.Min))
+		}
+		return d.Max - math.Sqrt((1-u)*span*(d.Max-d.Mode))
+	}
+}
+
+// percentileValue is one requested percentile of a sample.
+type percentileValue struct {
+	P     float64 `json:"p"`
+	Value float64 `json:"value"`
+}
+
+// sampleSummary describes one output across the feasible samples.
+type sampleSummary struct {
+	Mean        float64           `json:"mean"`
+	StdDev      float64           `json:"stdDev"`
+	Min         float64           `json:"min"`
+	Max         float64           `json:"max"`
+	Percentiles []percentileValue `json:"percentiles"`
+}
+
+// monteCarloResult summarises distance, optimal speed and remaining energy
+// over the samples whose inputs were valid and feasible.
+type monteCarloResult struct {
+	Samples           int           `json:"samples"`
+	Feasible          int           `json:"feasible"`
+	Seed              uint64        `json:"seed"`
+	DistanceM         sampleSummary `json:"distanceM"`
+	OptimalV          sampleSummary `json:"optimalV"`
+	RemainingEnergyWh sampleSummary `json:"remainingEnergyWh"`
+}
+
+// percentile linearly interpolates the p-th percentile (0..100) of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(lo)
+	return sorted[lo] + frac*(sorted[lo+1]-sorted[lo])
+}
+
+func summarizeSamples(values []float64, percentiles []float64) sampleSummary {
+	if len(values) == 0 {
+		return sampleSummary{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mean := 0.0
+	for _, v := range sorted {
+		mean += v
+	}
+	mean /= float64(len(sorted))
+	variance := 0.0
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	if len(sorted) > 1 {
+		variance /= float64(len(sorted) - 1)
+	}
+
+	summary := sampleSummary{Mean: mean, StdDev: math.Sqrt(variance), Min: sorted[0], Max: sorted[len(sorted)-1]}
+	for _, p := range percentiles {
+		summary.Percentiles = append(summary.Percentiles, percentileValue{P: p, Value: percentile(sorted, p)})
+	}
+	return summary
+}
+
+// runMonteCarlo draws samples input sets from distributions (fields without
+// one keep their base value), re-solves the optimal speed for each on a
+// worker pool and summarises the results. Inputs are drawn serially from
+// seed before any work starts, so a seed always reproduces the same result.
+func runMonteCarlo(base simulationInputs, distributions map[string]inputDistribution, samples int, seed uint64, percentiles []float64) (monteCarloResult, error) {
+	if samples <= 0 || samples > maxMonteCarloSamples {
+		return monteCarloResult{}, fmt.Errorf("samples must be between 1 and %d", maxMonteCarloSamples)
+	}
+	if len(percentiles) == 0 {
+		percentiles = defaultMonteCarloPercentiles
+	}
+	for _, p := range percentiles {
+		if p < 0 || p > 100 {
+			return monteCarloResult{}, fmt.Errorf("percentiles must be between 0 and 100")
+		}
+	}
+	// fixed field order so the draws do not depend on map iteration
+	fields := make([]string, 0, len(distributions))
+	resolved := make(map[string]inputDistribution, len(distributions))
+	for field, dist := range distributions {
+		if _, ok := inputFieldIndex[field]; !ok {
+			return monteCarloResult{}, unknownInputField(field)
+		}
+		if err := dist.validate(); err != nil {
+			return monteCarloResult{}, fmt.Errorf("%s: %w", field, err)
+		}
+		if dist.Kind == "normal" && dist.Mean == nil {
+			mean, _ := inputField(base, field)
+			dist.Mean = &mean
+		}
+		resolved[field] = dist
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	rng := rand.New(rand.NewPCG(seed, 0))
+	draws := make([]simulationInputs, samples)
+	for i := range draws {
+		draws[i] = base
+		for _, field := range fields {
+			_ = setInputField(&draws[i], field, resolved[field].sample(rng))
+		}
+	}
+
+	type outcome struct {
+		ok                       bool
+		distance, v, remainingWh float64
+	}
+	outcomes := make([]outcome, samples)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				search, ok := optimalDistance(draws[i])
+				if !ok {
+					continue
+				}
+				inputs := draws[i]
+				inputs.V = search.X
+				outcomes[i] = outcome{ok: true, distance: search.Value, v: search.X, remainingWh: remainingEnergyForInputs(inputs)}
+			}
+		}()
+	}
+	for i := range draws {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var distances, speeds, remaining []float64
+	for _, o := range outcomes {
+		if o.ok {
+			distances = append(distances, o.distance)
+			speeds = append(speeds, o.v)
+			remaining = append(remaining, o.remainingWh)
+		}
+	}
+	if len(distances) == 0 {
+		return monteCarloResult{}, fmt.Errorf("no sampled inputs were feasible for the model")
+	}
+	return monteCarloResult{
+		Samples:           samples,
+		Feasible:          len(distances),
+		Seed:              seed,
+		DistanceM:         summarizeSamples(distances, percentiles),
+		OptimalV:          summarizeSamples(speeds, percentiles),
+		RemainingEnergyWh: summarizeSamples(remaining, percentiles),
+	}, nil
+}
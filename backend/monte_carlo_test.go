@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func testDistributions() map[string]inputDistribution {
+	base := defaultSimulationInputs()
+	return map[string]inputDistribution{
+		"cRr":      {Kind: "normal", Mean: &base.Crr, StdDev: base.Crr * 0.1},
+		"cD":       {Kind: "uniform", Min: base.Cd * 0.9, Max: base.Cd * 1.1},
+		"etaDrive": {Kind: "triangular", Min: base.EtaDrive - 0.05, Mode: base.EtaDrive, Max: math.Min(1, base.EtaDrive+0.02)},
+	}
+}
+
+func TestRunMonteCarloIsReproducibleForASeed(t *testing.T) {
+	a, err := runMonteCarlo(defaultSimulationInputs(), testDistributions(), 200, 7, nil)
+	if err != nil {
+		t.Fatalf("runMonteCarlo returned error: %v", err)
+	}
+	b, err := runMonteCarlo(defaultSimulationInputs(), testDistributions(), 200, 7, nil)
+	if err != nil {
+		t.Fatalf("runMonteCarlo returned error: %v", err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same seed gave different results:\n%+v\n%+v", a, b)
+	}
+	c, _ := runMonteCarlo(defaultSimulationInputs(), testDistributions(), 200, 8, nil)
+	if c.DistanceM.Mean == a.DistanceM.Mean {
+		t.Fatal("different seeds gave the same mean distance")
+	}
+}
+
+func TestRunMonteCarloPercentilesBracketTheBaseline(t *testing.T) {
+	base := defaultSimulationInputs()
+	got, err := runMonteCarlo(base, testDistributions(), 500, 1, []float64{10, 50, 90})
+	if err != nil {
+		t.Fatalf("runMonteCarlo returned error: %v", err)
+	}
+	if got.Feasible != 500 {
+		t.Fatalf("got %d feasible samples, want 500", got.Feasible)
+	}
+	p := got.DistanceM.Percentiles
+	if len(p) != 3 || !(p[0].Value < p[1].Value && p[1].Value < p[2].Value) {
+		t.Fatalf("got percentiles %+v, want increasing P10 < P50 < P90", p)
+	}
+	baseline, _ := optimalDistance(base)
+	if baseline.Value < p[0].Value || baseline.Value > p[2].Value {
+		t.Fatalf("got baseline %.1f m outside P10..P90 [%.1f, %.1f]", baseline.Value, p[0].Value, p[2].Value)
+	}
+}
+
+func TestRunMonteCarloNormalDefaultsMeanToBaseValue(t *testing.T) {
+	base := defaultSimulationInputs()
+	dists := map[string]inputDistribution{"m": {Kind: "normal", StdDev: 0}}
+	got, err := runMonteCarlo(base, dists, 20, 1, nil)
+	if err != nil {
+		t.Fatalf("runMonteCarlo returned error: %v", err)
+	}
+	baseline, _ := optimalDistance(base)
+	if got.Feasible != 20 || math.Abs(got.DistanceM.Mean-baseline.Value) > 1e-6 {
+		t.Fatalf("got %d feasible with mean %.3f m, want 20 at the baseline %.3f m", got.Feasible, got.DistanceM.Mean, baseline.Value)
+	}
+	if dists["m"].Mean != nil {
+		t.Fatal("runMonteCarlo changed the caller's distribution")
+	}
+}
+
+func TestInputDistributionTriangularStaysInRange(t *testing.T) {
+	d := inputDistribution{Kind: "triangular", Min: 1, Mode: 2, Max: 5}
+	rng := rand.New(rand.NewPCG(1, 0))
+	mean := 0.0
+	for i := 0; i < 20000; i++ {
+		v := d.sample(rng)
+		if v < d.Min || v > d.Max {
+			t.Fatalf("got sample %.6f outside [%.0f, %.0f]", v, d.Min, d.Max)
+		}
+		mean += v / 20000
+	}
+	// triangular mean is (min + mode + max) / 3
+	if math.Abs(mean-8.0/3) > 0.05 {
+		t.Fatalf("got mean %.4f, want about %.4f", mean, 8.0/3)
+	}
+}
+
+func TestMonteCarloHandlerRejectsUnknownKind(t *testing.T) {
+	body, err := json.Marshal(monteCarloRequest{
+		Inputs:        defaultSimulationInputs(),
+		Distributions: map[string]inputDistribution{"cD": {Kind: "lognormal"}},
+		Samples:       10,
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/montecarlo", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	monteCarloHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
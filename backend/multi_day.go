@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxForecastDays is the furthest ahead Open-Meteo will forecast.
+const maxForecastDays = 16
+
+// maxMultiDayRaceMin keeps a 09:00 start and the two-hour evening charge
+// inside the day.
+const maxMultiDayRaceMin = 13 * 60
+
+// multiDayResult is one day of a multi-day event. Laps and SOC percentages are
+// derived from the embedded raceDayResult so the strategy table reads directly.
+type multiDayResult struct {
+	Day  int    `json:"day"`
+	Date string `json:"date"`
+	raceDayResult
+	Laps           float64 `json:"laps"`
+	RaceStartSOC   float64 `json:"raceStartSoc"` // %
+	RaceEndSOC     float64 `json:"raceEndSoc"`   // %
+	OvernightSOC   float64 `json:"overnightSoc"` // %
+	CumulativeDist float64 `json:"cumulativeDistanceM"`
+}
+
+// forecastDaysThrough returns the forecast_days value needed for a forecast
+// requested at now to include every hour of lastDay.
+func forecastDaysThrough(lastDay, now time.Time) int {
+	loc := lastDay.Location()
+	n := now.In(loc)
+	today := time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, loc)
+	last := time.Date(lastDay.Year(), lastDay.Month(), lastDay.Day(), 0, 0, 0, 0, loc)
+	return int(math.Round(last.Sub(today).Hours()/24)) + 1
+}
+
+// selectWeatherForDays points site at the weather covering days race days
+// from firstDay. Days already in the past replay the archive automatically;
+// upcoming days use the forecast, which must reach the last day.
+func selectWeatherForDays(site *solarSite, firstDay time.Time, days int, now time.Time) error {
+	if site.Archive != nil {
+		return nil
+	}
+	forecastDays := forecastDaysThrough(firstDay.AddDate(0, 0, days-1), now)
+	if forecastDays < 1 {
+		site.Archive = &weatherArchive{}
+		return nil
+	}
+	if forecastDays < days {
+		return fmt.Errorf("race days span both past and forecast weather")
+	}
+	if forecastDays > maxForecastDays {
+		return fmt.Errorf("race days fall outside the forecast window")
+	}
+	site.ForecastDays = forecastDays
+	return nil
+}
+
+// simulateMultiDay runs consecutive race days. Each day starts from the
+// previous day's overnight battery, so the evening charge of day N and the
+// morning charge of day N+1 both feed day N+1's race. lapLengthM converts
+// distance into laps; pass 0 to skip the lap count.
+func simulateMultiDay(
+	site solarSite,
+	schedules []raceDaySchedule,
+	inputs simulationInputs,
+	startBatteryWh float64,
+	lapLengthM float64,
+) ([]multiDayResult, error) {
+	if len(schedules) == 0 {
+		return nil, fmt.Errorf("at least one race day is required")
+	}
+	capacityWh := inputs.BatteryWh
+	soc := func(wh float64) float64 {
+		return wh / capacityWh * 100
+	}
+
+	results := make([]multiDayResult, 0, len(schedules))
+	batteryWh := startBatteryWh
+	cumulative := 0.0
+	for i, schedule := range schedules {
+		day, err := simulateRaceDay(site, schedule, inputs, batteryWh)
+		if err != nil {
+			return nil, fmt.Errorf("day %d: %w", i+1, err)
+		}
+		cumulative += day.DistanceM
+
+		laps := 0.0
+		if lapLengthM > 0 {
+			laps = day.DistanceM / lapLengthM
+		}
+		results = append(results, multiDayResult{
+			Day:            i + 1,
+			Date:           schedule.Race.Start.Format("2006-01-02"),
+			raceDayResult:  day,
+			Laps:           laps,
+			RaceStartSOC:   soc(day.RaceStartBatteryWh),
+			RaceEndSOC:     soc(day.RaceEndBatteryWh),
+			OvernightSOC:   soc(day.OvernightBatteryWh),
+			CumulativeDist: cumulative,
+		})
+		batteryWh = day.OvernightBatteryWh
+	}
+
+	return results, nil
+}
+
+// defaultMultiDaySchedules lays out days consecutive default race days
+// starting on firstDay.
+func defaultMultiDaySchedules(firstDay time.Time, days int) []raceDaySchedule {
+	schedules := make([]raceDaySchedule, 0, days)
+	for d := 0; d < days; d++ {
+		schedules = append(schedules, defaultRaceDaySchedule(firstDay.AddDate(0, 0, d), firstDay.Location()))
+	}
+	return schedules
+}
+
+// multiDaySchedules lays out days consecutive race days like
+// defaultMultiDaySchedules but with a raceDayMin-minute race from 09:00; the
+// evening charge keeps its two hours and follows the finish.
+func multiDaySchedules(firstDay time.Time, days int, raceDayMin float64) ([]raceDaySchedule, error) {
+	if raceDayMin <= 0 || raceDayMin > maxMultiDayRaceMin {
+		return nil, fmt.Errorf("multi-day races need raceDayMin above 0 and at most %d", maxMultiDayRaceMin)
+	}
+	schedules := defaultMultiDaySchedules(firstDay, days)
+	for i := range schedules {
+		s := &schedules[i]
+		charge := s.PostRace.End.Sub(s.PostRace.Start)
+		s.Race.End = s.Race.Start.Add(time.Duration(raceDayMin * float64(time.Minute)))
+		s.PostRace.Start = s.Race.End
+		s.PostRace.End = s.PostRace.Start.Add(charge)
+	}
+	return schedules, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Defaults for the cruise-speed search. The upper bound is well past any
+// road car so it only binds for inputs that are not physical.
+const (
+	defaultSpeedSearchLower   = 0.5   // m/s
+	defaultSpeedSearchUpper   = 150.0 // m/s
+	defaultSpeedSearchStep    = 1.0   // m/s, first bracketing step
+	defaultSpeedSearchTol     = 1e-3  // m/s
+	defaultOptimizerIterLimit = 200
+)
+
+// optimizeOptions bounds a 1-D maximisation.
+type optimizeOptions struct {
+	Lower   float64 `json:"lower"`
+	Upper   float64 `json:"upper"`
+	Step    float64 `json:"step"`      // first bracketing step; expands by the golden ratio
+	Tol     float64 `json:"tolerance"` // final bracket width
+	MaxIter int     `json:"maxIter"`
+}
+
+// optimizeResult is where a 1-D maximisation ended and how it got there.
+type optimizeResult struct {
+	X          float64 `json:"x"`
+	Value      float64 `json:"value"`
+	Iterations int     `json:"iterations"` // bracketing steps plus golden-section steps
+	HitBound   bool    `json:"hitBound"`   // optimum is at Lower or Upper, so the true one may lie outside
+	Converged  bool    `json:"converged"`  // bracket shrank below Tol within MaxIter
+}
+
+// defaultSpeedSearchOptions are the bounds computeOptimalSpeedForInputs uses.
+func defaultSpeedSearchOptions() optimizeOptions {
+	return optimizeOptions{
+		Lower:   defaultSpeedSearchLower,
+		Upper:   defaultSpeedSearchUpper,
+		Step:    defaultSpeedSearchStep,
+		Tol:     defaultSpeedSearchTol,
+		MaxIter: defaultOptimizerIterLimit,
+	}
+}
+
+// maximizeUnimodal maximises f on [opts.Lower, opts.Upper]. It walks up from
+// Lower with growing steps until f stops improving, which brackets the peak,
+// then shrinks the bracket by golden-section search. f reports false where
+// it is not defined (for example a speed the drivetrain cannot hold); those
+// points count as worse than any defined value.
+func maximizeUnimodal(f func(x float64) (float64, bool), opts optimizeOptions) (optimizeResult, error) {
+	if !(opts.Upper > opts.Lower) || opts.Tol <= 0 {
+		return optimizeResult{}, fmt.Errorf("optimizer needs lower < upper and a positive tolerance")
+	}
+	if opts.Step <= 0 {
+		opts.Step = (opts.Upper - opts.Lower) / 100
+	}
+	if opts.MaxIter <= 0 {
+		opts.MaxIter = defaultOptimizerIterLimit
+	}
+	eval := func(x float64) float64 {
+		if v, ok := f(x); ok && !math.IsNaN(v) {
+			return v
+		}
+		return math.Inf(-1)
+	}
+	phi := (1 + math.Sqrt(5)) / 2
+	result := optimizeResult{}
+
+	// bracket the peak in [lo, hi], with b the best point seen so far
+	a := opts.Lower
+	b := math.Min(opts.Lower+opts.Step, opts.Upper)
+	fa, fb := eval(a), eval(b)
+	lo, hi := a, b
+	if fb < fa {
+		b, fb = a, fa
+	} else {
+		for result.Iterations < opts.MaxIter {
+			result.Iterations++
+			if b >= opts.Upper {
+				lo, hi = a, b
+				break
+			}
+			c := math.Min(b+phi*(b-a), opts.Upper)
+			fc := eval(c)
+			if fc < fb {
+				lo, hi = a, c
+				break
+			}
+			a, b, fb = b, c, fc
+			lo, hi = a, b
+		}
+	}
+
+	// golden-section on [lo, hi]
+	invPhi := 1 / phi
+	x1 := hi - invPhi*(hi-lo)
+	x2 := lo + invPhi*(hi-lo)
+	f1, f2 := eval(x1), eval(x2)
+	for hi-lo > opts.Tol && result.Iterations < opts.MaxIter {
+		result.Iterations++
+		if f1 < f2 {
+			lo, x1, f1 = x1, x2, f2
+			x2 = lo + invPhi*(hi-lo)
+			f2 = eval(x2)
+		} else {
+			hi, x2, f2 = x2, x1, f1
+			x1 = hi - invPhi*(hi-lo)
+			f1 = eval(x1)
+		}
+	}
+	result.Converged = hi-lo <= opts.Tol
+
+	// best of the final bracket, its interior points and the bracket seed
+	result.X, result.Value = b, fb
+	for _, x := range []float64{lo, x1, x2, hi} {
+		if v := eval(x); v > result.Value {
+			result.X, result.Value = x, v
+		}
+	}
+	if math.IsInf(result.Value, -1) {
+		return result, fmt.Errorf("objective is not defined anywhere in [%g, %g]", opts.Lower, opts.Upper)
+	}
+	result.HitBound = result.X-opts.Lower <= opts.Tol || opts.Upper-result.X <= opts.Tol
+	return result, nil
+}
+
+// optimalSpeedSearch maximises DistanceForSpeedEV over cruise speed.
+func optimalSpeedSearch(inputs simulationInputs, opts optimizeOptions) (optimizeResult, error) {
+	return maximizeUnimodal(func(v float64) (float64, bool) {
+		inputs.V = v
+		return distanceForInputs(inputs)
+	}, opts)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMaximizeUnimodalFindsInteriorPeak(t *testing.T) {
+	got, err := maximizeUnimodal(func(x float64) (float64, bool) {
+		return -(x - 7.3) * (x - 7.3), true
+	}, optimizeOptions{Lower: 0, Upper: 100, Step: 1, Tol: 1e-6})
+	if err != nil {
+		t.Fatalf("maximizeUnimodal returned error: %v", err)
+	}
+	if math.Abs(got.X-7.3) > 1e-5 {
+		t.Fatalf("got optimum %.8f, want 7.3", got.X)
+	}
+	if !got.Converged || got.HitBound || got.Iterations == 0 {
+		t.Fatalf("got converged=%v hitBound=%v iterations=%d, want a converged interior search", got.Converged, got.HitBound, got.Iterations)
+	}
+}
+
+func TestMaximizeUnimodalReportsBound(t *testing.T) {
+	got, err := maximizeUnimodal(func(x float64) (float64, bool) {
+		return x, true
+	}, optimizeOptions{Lower: 0, Upper: 10, Step: 1, Tol: 1e-6})
+	if err != nil {
+		t.Fatalf("maximizeUnimodal returned error: %v", err)
+	}
+	if !got.HitBound || math.Abs(got.X-10) > 1e-6 {
+		t.Fatalf("got x=%.6f hitBound=%v, want the upper bound flagged", got.X, got.HitBound)
+	}
+}
+
+func TestMaximizeUnimodalSkipsUndefinedPoints(t *testing.T) {
+	// undefined above 20, like a speed the drivetrain cannot hold
+	got, err := maximizeUnimodal(func(x float64) (float64, bool) {
+		return x, x <= 20
+	}, optimizeOptions{Lower: 1, Upper: 100, Step: 1, Tol: 1e-6})
+	if err != nil {
+		t.Fatalf("maximizeUnimodal returned error: %v", err)
+	}
+	if math.Abs(got.X-20) > 1e-5 {
+		t.Fatalf("got optimum %.8f, want 20", got.X)
+	}
+}
+
+func TestOptimalSpeedBeatsFineGrid(t *testing.T) {
+	for _, preset := range simulationPresets {
+		inputs := preset.Inputs
+		got, err := optimalSpeedSearch(inputs, defaultSpeedSearchOptions())
+		if err != nil {
+			t.Fatalf("%s: optimalSpeedSearch returned error: %v", preset.ID, err)
+		}
+		for v := 0.5; v <= defaultSpeedSearchUpper; v += 0.05 {
+			inputs.V = v
+			if d, ok := distanceForInputs(inputs); ok && d > got.Value+1e-6*got.Value {
+				t.Fatalf("%s: grid found %.3f m at %.2f m/s, above the optimizer's %.3f m at %.4f m/s", preset.ID, d, v, got.Value, got.X)
+			}
+		}
+	}
+}
+
+func TestOptimalSpeedSearchesAboveOldCeiling(t *testing.T) {
+	var inputs simulationInputs
+	for _, preset := range simulationPresets {
+		if preset.ID == "lexus-gs350-awd" {
+			inputs = preset.Inputs
+		}
+	}
+	// a pack big enough that the car should run flat out for the whole race
+	inputs.BatteryWh *= 10
+
+	v := computeOptimalSpeedForInputs(inputs)
+	if v <= 40 {
+		t.Fatalf("got optimal speed %.3f m/s, want above the old 40 m/s ceiling", v)
+	}
+}
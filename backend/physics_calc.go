@@ -174,6 +174,22 @@ func backwardCoastFeasibilityPass(
 	return feasible
 }
 
+// capSpeedProfileBySegment lowers each sample's limit to its segment's target
+// speed. Segment indexes wrap modulo len(segmentCaps) so a plan for one lap
+// also applies to repeated laps; non-positive caps leave the sample alone.
+func capSpeedProfileBySegment(profile speedProfile, samples []trackSample, segmentCaps []float64) speedProfile {
+	if len(segmentCaps) == 0 || len(samples) != len(profile) {
+		return profile
+	}
+	for i, s := range samples {
+		limit := segmentCaps[s.SegmentIndex%len(segmentCaps)]
+		if limit > 0 && profile[i] > limit {
+			profile[i] = limit
+		}
+	}
+	return profile
+}
+
 // buildProfiles constructs base, brake-feasible, and coast-feasible profiles together.
 func buildProfiles(
 	samples []trackSample,
@@ -189,7 +205,27 @@ func buildProfiles(
 	theta float64,
 	additionalEfficiency float64,
 ) profileSet {
-	base := buildSpeedProfile(samples, cruiseCapMPS)
+	return buildProfilesWithSegmentCaps(samples, nil, cruiseCapMPS, maxBrakeMPS2, vMin, m, g, Crr, rho, Cd, A, theta, additionalEfficiency)
+}
+
+// buildProfilesWithSegmentCaps is buildProfiles with an extra per-segment
+// speed plan applied to the base profile before the feasibility passes.
+func buildProfilesWithSegmentCaps(
+	samples []trackSample,
+	segmentCaps []float64,
+	cruiseCapMPS float64,
+	maxBrakeMPS2 float64,
+	vMin float64,
+	m float64,
+	g float64,
+	Crr float64,
+	rho float64,
+	Cd float64,
+	A float64,
+	theta float64,
+	additionalEfficiency float64,
+) profileSet {
+	base := capSpeedProfileBySegment(buildSpeedProfile(samples, cruiseCapMPS), samples, segmentCaps)
 	brake := backwardFeasibilityPass(base, samples, maxBrakeMPS2)
 	coast := backwardCoastFeasibilityPass(base, samples, vMin, m, g, Crr, rho, Cd, A, theta, additionalEfficiency)
 	return profileSet{
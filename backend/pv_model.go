@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// stcCellTempC is the cell temperature at Standard Test Conditions, where the
+// datasheet panel efficiency is quoted.
+const stcCellTempC = 25.0
+
+// mpptPoint is one point on the tracker efficiency curve.
+type mpptPoint struct {
+	IrradianceWm2 float64 `json:"irradianceWm2"`
+	Efficiency    float64 `json:"efficiency"` // 0..1
+}
+
+// pvModel adjusts the datasheet cell efficiency for operating conditions.
+// Cell temperature uses the Faiman model:
+//
+//	Tcell = Tamb + G / (U0 + U1*wind)
+//
+// and power scales linearly with (Tcell - 25 °C) by TempCoeffPerC.
+type pvModel struct {
+	TempCoeffPerC float64     `json:"tempCoeffPerC"` // fractional power change per °C, e.g. -0.0035
+	U0            float64     `json:"u0"`            // constant heat loss [W/m²K]
+	U1            float64     `json:"u1"`            // wind-driven heat loss [W·s/m³K]
+	MPPTCurve     []mpptPoint `json:"mpptCurve"`     // sorted or not; interpolated by irradiance
+}
+
+// defaultPVModel is a monocrystalline silicon array on an open car body with a
+// typical solar-car MPPT that loses efficiency at low light.
+func defaultPVModel() *pvModel {
+	return &pvModel{
+		TempCoeffPerC: -0.0035,
+		U0:            25.0,
+		U1:            6.84,
+		MPPTCurve: []mpptPoint{
+			{IrradianceWm2: 0, Efficiency: 0},
+			{IrradianceWm2: 50, Efficiency: 0.90},
+			{IrradianceWm2: 100, Efficiency: 0.95},
+			{IrradianceWm2: 200, Efficiency: 0.97},
+			{IrradianceWm2: 400, Efficiency: 0.98},
+			{IrradianceWm2: 1000, Efficiency: 0.99},
+		},
+	}
+}
+
+// cellTemperature estimates the cell temperature [°C] from irradiance,
+// ambient temperature and wind speed.
+func (pv *pvModel) cellTemperature(gtiWm2, ambientC, windMPS float64) float64 {
+	if gtiWm2 <= 0 {
+		return ambientC
+	}
+	u := pv.U0 + pv.U1*max(windMPS, 0)
+	if u <= 0 {
+		return ambientC
+	}
+	return ambientC + gtiWm2/u
+}
+
+// mpptEfficiency linearly interpolates the tracker curve at gtiWm2 and holds
+// the end values outside the curve. An empty curve means an ideal tracker.
+func (pv *pvModel) mpptEfficiency(gtiWm2 float64) float64 {
+	curve := pv.MPPTCurve
+	if len(curve) == 0 {
+		return 1
+	}
+	if !sort.SliceIsSorted(curve, func(i, j int) bool { return curve[i].IrradianceWm2 < curve[j].IrradianceWm2 }) {
+		curve = append([]mpptPoint(nil), curve...)
+		sort.Slice(curve, func(i, j int) bool { return curve[i].IrradianceWm2 < curve[j].IrradianceWm2 })
+	}
+
+	if gtiWm2 <= curve[0].IrradianceWm2 {
+		return curve[0].Efficiency
+	}
+	for i := 1; i < len(curve); i++ {
+		hi := curve[i]
+		if gtiWm2 <= hi.IrradianceWm2 {
+			lo := curve[i-1]
+			frac := (gtiWm2 - lo.IrradianceWm2) / (hi.IrradianceWm2 - lo.IrradianceWm2)
+			return lo.Efficiency + frac*(hi.Efficiency-lo.Efficiency)
+		}
+	}
+	return curve[len(curve)-1].Efficiency
+}
+
+// powerW returns the electrical power [W] delivered after the MPPT for an
+// array of panelArea m² with STC efficiency panelEff. systemEff still covers
+// wiring and battery charge losses.
+func (pv *pvModel) powerW(gtiWm2, ambientC, windMPS, panelArea, panelEff, systemEff float64) float64 {
+	if gtiWm2 <= 0 {
+		return 0
+	}
+	tCell := pv.cellTemperature(gtiWm2, ambientC, windMPS)
+	cellEff := panelEff * (1 + pv.TempCoeffPerC*(tCell-stcCellTempC))
+	if cellEff < 0 {
+		cellEff = 0
+	}
+	return gtiWm2 * panelArea * cellEff * pv.mpptEfficiency(gtiWm2) * systemEff
+}
+
+// conditionsAt returns the ambient temperature and wind speed for hour i,
+// falling back to STC temperature and still air where samples are missing.
+func (w HourlyWeather) conditionsAt(i int) (float64, float64) {
+	ambientC, windMPS := stcCellTempC, 0.0
+	if i < len(w.TempC) {
+		ambientC = w.TempC[i]
+	}
+	if i < len(w.WindMPS) {
+		windMPS = w.WindMPS[i]
+	}
+	return ambientC, windMPS
+}
+
+// energyFromWeatherSeries is energyFromGTISeries with the PV model applied to
+// each hour instead of a constant panel efficiency.
+func energyFromWeatherSeries(
+	weather HourlyWeather,
+	pv *pvModel,
+
+	panelArea float64,
+	panelEff float64,
+	systemEff float64,
+
+	dt time.Duration,
+	spanStart *time.Time,
+	spanEnd *time.Time,
+) float64 {
+	dtHours := dt.Hours()
+	totalEnergy := 0.0
+
+	n := min(len(weather.Times), len(weather.GTI))
+	for i := 0; i < n; i++ {
+		t := weather.Times[i]
+		if spanStart != nil && t.Before(*spanStart) {
+			continue
+		}
+		if spanEnd != nil && !t.Before(*spanEnd) {
+			break
+		}
+
+		ambientC, windMPS := weather.conditionsAt(i)
+		totalEnergy += pv.powerW(weather.GTI[i], ambientC, windMPS, panelArea, panelEff, systemEff) * dtHours
+	}
+
+	return totalEnergy
+}
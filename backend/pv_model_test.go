@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPVModelHotDayProducesLessThanCoolDay(t *testing.T) {
+	pv := defaultPVModel()
+
+	cool := pv.powerW(1000, 15, 3, 4.0, 0.22, 0.9)
+	hot := pv.powerW(1000, 38, 3, 4.0, 0.22, 0.9)
+	if hot >= cool {
+		t.Fatalf("got hot-day power %.3f W, want less than cool-day %.3f W", hot, cool)
+	}
+}
+
+func TestPVModelWindCoolsCells(t *testing.T) {
+	pv := defaultPVModel()
+
+	still := pv.cellTemperature(800, 30, 0)
+	windy := pv.cellTemperature(800, 30, 8)
+	if windy >= still {
+		t.Fatalf("got windy cell temp %.3f °C, want below still-air %.3f °C", windy, still)
+	}
+	if math.Abs(still-(30+800/pv.U0)) > 1e-9 {
+		t.Fatalf("got still-air cell temp %.6f °C, want %.6f", still, 30+800/pv.U0)
+	}
+}
+
+func TestMPPTEfficiencyInterpolatesCurve(t *testing.T) {
+	pv := &pvModel{MPPTCurve: []mpptPoint{
+		{IrradianceWm2: 1000, Efficiency: 0.99},
+		{IrradianceWm2: 0, Efficiency: 0.5},
+	}}
+
+	if got := pv.mpptEfficiency(500); math.Abs(got-0.745) > 1e-9 {
+		t.Fatalf("got MPPT efficiency %.6f at 500 W/m², want 0.745", got)
+	}
+	if got := pv.mpptEfficiency(1200); got != 0.99 {
+		t.Fatalf("got MPPT efficiency %.6f above curve, want 0.99", got)
+	}
+	if got := (&pvModel{}).mpptEfficiency(300); got != 1 {
+		t.Fatalf("got MPPT efficiency %.6f for empty curve, want 1", got)
+	}
+}
+
+func TestEnergyFromWeatherSeriesMatchesConstantEfficiencyAtSTC(t *testing.T) {
+	start := time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)
+	weather := HourlyWeather{
+		Times:   []time.Time{start, start.Add(time.Hour)},
+		GTI:     []float64{1000, 1000},
+		TempC:   []float64{25, 25},
+		WindMPS: []float64{0, 0},
+	}
+	// with no temperature or MPPT losses the model reduces to GTI*area*eff*sys
+	pv := &pvModel{TempCoeffPerC: -0.0035, U0: math.Inf(1)}
+
+	got := energyFromWeatherSeries(weather, pv, 4.0, 0.22, 0.9, time.Hour, nil, nil)
+	want, _ := energyFromGTISeries(weather.Times, weather.GTI, 4.0, 0.22, 0.9, time.Hour, 0, nil, nil)
+	if math.Abs(got-want) > 1e-9 {
+		t.Fatalf("got %.6f Wh, want %.6f Wh", got, want)
+	}
+}
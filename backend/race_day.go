@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// solarSite describes where the car is parked/racing and the array it carries.
+// Orientation lives on each chargeWindow because it changes through the day.
+type solarSite struct {
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+	Timezone     string  `json:"timezone"`
+	ForecastDays int     `json:"-"` // derived from the requested dates
+
+	PanelArea float64 `json:"panelArea"` // m²
+	PanelEff  float64 `json:"panelEff"`  // 0..1
+	SystemEff float64 `json:"systemEff"` // 0..1
+
+	// PV applies cell temperature and MPPT losses; nil keeps constant efficiency.
+	PV *pvModel `json:"pv,omitempty"`
+	// Zones splits the array by orientation; empty means one body-aligned
+	// zone of PanelArea at PanelEff.
+	Zones []arrayZone `json:"zones,omitempty"`
+	// Archive replays recorded weather instead of the forecast.
+	Archive *weatherArchive `json:"archive,omitempty"`
+}
+
+// chargeWindow is one period of the race day with its own array orientation.
+// tiltDeg/azimuthDeg follow the Open-Meteo convention used by FetchHourlyGTI.
+type chargeWindow struct {
+	Start      time.Time
+	End        time.Time
+	TiltDeg    float64
+	AzimuthDeg float64
+}
+
+func (w chargeWindow) minutes() float64 {
+	return w.End.Sub(w.Start).Minutes()
+}
+
+// raceDaySchedule splits a race day into static charging before the start,
+// the racing window itself, and static charging after the finish.
+// PreRace and PostRace may be zero-length if the rules don't allow charging.
+type raceDaySchedule struct {
+	PreRace  chargeWindow
+	Race     chargeWindow
+	PostRace chargeWindow
+}
+
+// raceDayResult reports the battery state at each hand-off of the schedule.
+type raceDayResult struct {
+	StartBatteryWh     float64 `json:"startBatteryWh"`
+	PreRaceChargeWh    float64 `json:"preRaceChargeWh"`
+	RaceStartBatteryWh float64 `json:"raceStartBatteryWh"`
+	RaceSolarWh        float64 `json:"raceSolarWh"`
+	RaceSolarWhPerMin  float64 `json:"raceSolarWhPerMin"`
+	OptimalV           float64 `json:"optimalV"`
+	DistanceM          float64 `json:"distanceM"`
+	RaceEndBatteryWh   float64 `json:"raceEndBatteryWh"`
+	PostRaceChargeWh   float64 `json:"postRaceChargeWh"`
+	OvernightBatteryWh float64 `json:"overnightBatteryWh"` // carried into the next day
+
+	PreRaceZones  []zoneEnergy `json:"preRaceZones"`
+	RaceZones     []zoneEnergy `json:"raceZones"`
+	PostRaceZones []zoneEnergy `json:"postRaceZones"`
+
+	// SkippedWindows names the charge windows ("preRace", "postRace") an
+	// archive file could not replay; they count as no charge.
+	SkippedWindows []string `json:"skippedWindows,omitempty"`
+}
+
+// raceWindowTiltDeg and raceWindowAzimuthDeg are the array orientation while
+// racing: near flat on the car.
+const (
+	raceWindowTiltDeg    = 5.0
+	raceWindowAzimuthDeg = 0.0
+)
+
+// defaultRaceDaySchedule builds the usual FSGP-style day in loc: tilted array
+// charging 07:00–09:00, racing 09:00–17:00 flat on the car, tilted charging
+// 17:00–19:00. Morning faces east and evening faces west.
+func defaultRaceDaySchedule(day time.Time, loc *time.Location) raceDaySchedule {
+	at := func(hour int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, loc)
+	}
+	return raceDaySchedule{
+		PreRace:  chargeWindow{Start: at(7), End: at(9), TiltDeg: 45, AzimuthDeg: -90},
+		Race:     chargeWindow{Start: at(9), End: at(17), TiltDeg: raceWindowTiltDeg, AzimuthDeg: raceWindowAzimuthDeg},
+		PostRace: chargeWindow{Start: at(17), End: at(19), TiltDeg: 45, AzimuthDeg: 90},
+	}
+}
+
+func validateRaceDaySchedule(schedule raceDaySchedule) error {
+	if schedule.Race.minutes() <= 0 {
+		return fmt.Errorf("race window must end after it starts")
+	}
+	if schedule.PreRace.minutes() < 0 || schedule.PostRace.minutes() < 0 {
+		return fmt.Errorf("charge windows must not end before they start")
+	}
+	if schedule.PreRace.End.After(schedule.Race.Start) {
+		return fmt.Errorf("pre-race charging overlaps the race window")
+	}
+	if schedule.PostRace.minutes() > 0 && schedule.PostRace.Start.Before(schedule.Race.End) {
+		return fmt.Errorf("post-race charging overlaps the race window")
+	}
+	return nil
+}
+
+// windowSolarEnergy returns the Wh the whole array collects over window.
+func windowSolarEnergy(site solarSite, window chargeWindow) (float64, error) {
+	zones, err := windowZoneEnergy(site, window)
+	if err != nil {
+		return 0, err
+	}
+	return totalZoneEnergy(zones), nil
+}
+
+// chargeWindowZones is windowZoneEnergy for a charge window. An archive file
+// holds GTI for one orientation only, so a charge window it cannot replay
+// adds name to result.SkippedWindows and collects nothing.
+func chargeWindowZones(site solarSite, window chargeWindow, name string, result *raceDayResult) ([]zoneEnergy, error) {
+	zones, err := windowZoneEnergy(site, window)
+	var orientation archiveOrientationError
+	if errors.As(err, &orientation) {
+		result.SkippedWindows = append(result.SkippedWindows, name)
+		return nil, nil
+	}
+	return zones, err
+}
+
+// simulateRaceDay walks one race day: charge from startBatteryWh during
+// PreRace, race at the optimal cruise speed with the race-window solar, then
+// charge again during PostRace. inputs.BatteryWh is treated as pack capacity,
+// so charging never overfills the pack. inputs.RaceDayMin and
+// inputs.SolarWhPerMin are replaced by values derived from the schedule.
+func simulateRaceDay(site solarSite, schedule raceDaySchedule, inputs simulationInputs, startBatteryWh float64) (raceDayResult, error) {
+	if err := validateRaceDaySchedule(schedule); err != nil {
+		return raceDayResult{}, err
+	}
+	capacityWh := inputs.BatteryWh
+	result := raceDayResult{StartBatteryWh: startBatteryWh}
+
+	preRaceZones, err := chargeWindowZones(site, schedule.PreRace, "preRace", &result)
+	if err != nil {
+		return raceDayResult{}, err
+	}
+	result.PreRaceZones = preRaceZones
+	result.PreRaceChargeWh = totalZoneEnergy(preRaceZones)
+	result.RaceStartBatteryWh = math.Min(capacityWh, startBatteryWh+result.PreRaceChargeWh)
+
+	raceZones, err := windowZoneEnergy(site, schedule.Race)
+	if err != nil {
+		return raceDayResult{}, err
+	}
+	result.RaceZones = raceZones
+	raceMin := schedule.Race.minutes()
+	result.RaceSolarWh = totalZoneEnergy(raceZones)
+	result.RaceSolarWhPerMin = result.RaceSolarWh / raceMin
+
+	race := inputs
+	// the reserve is a share of the pack, not of the charge at the start line
+	race.MinReserveWh, race.MinReservePct = inputs.reserveWh(), 0
+	race.BatteryWh = result.RaceStartBatteryWh
+	race.SolarWhPerMin = result.RaceSolarWhPerMin
+	race.RaceDayMin = raceMin
+	race.V = computeOptimalSpeedForInputs(race)
+	distance, ok := distanceForInputs(race)
+	if !ok {
+		return raceDayResult{}, fmt.Errorf("inputs are not feasible for the model")
+	}
+	result.OptimalV = race.V
+	result.DistanceM = distance
+	result.RaceEndBatteryWh = remainingEnergyForInputs(race)
+
+	postRaceZones, err := chargeWindowZones(site, schedule.PostRace, "postRace", &result)
+	if err != nil {
+		return raceDayResult{}, err
+	}
+	result.PostRaceZones = postRaceZones
+	result.PostRaceChargeWh = totalZoneEnergy(postRaceZones)
+	result.OvernightBatteryWh = math.Min(capacityWh, result.RaceEndBatteryWh+result.PostRaceChargeWh)
+
+	return result, nil
+}
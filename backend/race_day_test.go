@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// stubHourlyGTI replaces the Open-Meteo fetch with a flat hourly series of
+// gtiWm2 covering days full days from day's midnight in loc.
+func stubHourlyGTI(t *testing.T, day time.Time, loc *time.Location, days int, gtiWm2 float64) {
+	t.Helper()
+	prevGTI, prevWeather := fetchHourlyGTI, fetchHourlyWeather
+	t.Cleanup(func() { fetchHourlyGTI, fetchHourlyWeather = prevGTI, prevWeather })
+
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	fetchHourlyWeather = func(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDays int) (HourlyWeather, error) {
+		var weather HourlyWeather
+		for h := 0; h < 24*days; h++ {
+			weather.Times = append(weather.Times, midnight.Add(time.Duration(h)*time.Hour))
+			weather.GTI = append(weather.GTI, gtiWm2)
+			weather.TempC = append(weather.TempC, 25)
+			weather.WindMPS = append(weather.WindMPS, 1)
+		}
+		return weather, nil
+	}
+	fetchHourlyGTI = func(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDays int) ([]time.Time, []float64, error) {
+		weather, err := fetchHourlyWeather(lat, lon, tiltDeg, azimuthDeg, tz, forecastDays)
+		return weather.Times, weather.GTI, err
+	}
+}
+
+func testSolarSite() solarSite {
+	return solarSite{
+		Lat: 29.6516, Lon: -82.3248,
+		Timezone:     "UTC",
+		ForecastDays: 1,
+		PanelArea:    4.0,
+		PanelEff:     0.25,
+		SystemEff:    1.0,
+	}
+}
+
+func TestSimulateRaceDayComputesRaceStartAndOvernightBattery(t *testing.T) {
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	stubHourlyGTI(t, day, time.UTC, 1, 1000) // 1000 W/m² * 4 m² * 0.25 = 1000 W
+
+	inputs := defaultSimulationInputs()
+	schedule := defaultRaceDaySchedule(day, time.UTC)
+
+	got, err := simulateRaceDay(testSolarSite(), schedule, inputs, 2000)
+	if err != nil {
+		t.Fatalf("simulateRaceDay returned error: %v", err)
+	}
+
+	if math.Abs(got.PreRaceChargeWh-2000) > 1e-9 {
+		t.Fatalf("got pre-race charge %.6f Wh, want 2000", got.PreRaceChargeWh)
+	}
+	if math.Abs(got.RaceStartBatteryWh-4000) > 1e-9 {
+		t.Fatalf("got race start battery %.6f Wh, want 4000", got.RaceStartBatteryWh)
+	}
+	if math.Abs(got.RaceSolarWhPerMin-1000.0/60.0) > 1e-9 {
+		t.Fatalf("got race solar %.6f Wh/min, want %.6f", got.RaceSolarWhPerMin, 1000.0/60.0)
+	}
+	if got.DistanceM <= 0 {
+		t.Fatalf("got distance %.6f, want positive", got.DistanceM)
+	}
+	want := math.Min(inputs.BatteryWh, got.RaceEndBatteryWh+got.PostRaceChargeWh)
+	if math.Abs(got.OvernightBatteryWh-want) > 1e-9 {
+		t.Fatalf("got overnight battery %.6f Wh, want %.6f", got.OvernightBatteryWh, want)
+	}
+}
+
+func TestSimulateRaceDayCapsChargingAtPackCapacity(t *testing.T) {
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	stubHourlyGTI(t, day, time.UTC, 1, 1000)
+
+	inputs := defaultSimulationInputs()
+	got, err := simulateRaceDay(testSolarSite(), defaultRaceDaySchedule(day, time.UTC), inputs, inputs.BatteryWh)
+	if err != nil {
+		t.Fatalf("simulateRaceDay returned error: %v", err)
+	}
+	if got.RaceStartBatteryWh != inputs.BatteryWh {
+		t.Fatalf("got race start battery %.6f Wh, want capacity %.6f", got.RaceStartBatteryWh, inputs.BatteryWh)
+	}
+}
+
+func TestSimulateRaceDayRejectsOverlappingWindows(t *testing.T) {
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	schedule := defaultRaceDaySchedule(day, time.UTC)
+	schedule.PreRace.End = schedule.Race.Start.Add(time.Hour)
+
+	if _, err := simulateRaceDay(testSolarSite(), schedule, defaultSimulationInputs(), 0); err == nil {
+		t.Fatal("expected error for pre-race window overlapping the race")
+	}
+}
+
+func TestSimulateMultiDayCarriesOvernightBatteryIntoNextDay(t *testing.T) {
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	stubHourlyGTI(t, day, time.UTC, 3, 200)
+
+	inputs := defaultSimulationInputs()
+	got, err := simulateMultiDay(testSolarSite(), defaultMultiDaySchedules(day, 3), inputs, inputs.BatteryWh, 1000)
+	if err != nil {
+		t.Fatalf("simulateMultiDay returned error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d days, want 3", len(got))
+	}
+
+	for i := 1; i < len(got); i++ {
+		if got[i].StartBatteryWh != got[i-1].OvernightBatteryWh {
+			t.Fatalf("day %d started at %.6f Wh, want previous overnight %.6f Wh", got[i].Day, got[i].StartBatteryWh, got[i-1].OvernightBatteryWh)
+		}
+		if got[i].Date == got[i-1].Date {
+			t.Fatalf("day %d repeats date %s", got[i].Day, got[i].Date)
+		}
+	}
+	if math.Abs(got[0].Laps-got[0].DistanceM/1000) > 1e-9 {
+		t.Fatalf("got %.6f laps, want %.6f", got[0].Laps, got[0].DistanceM/1000)
+	}
+	if got[2].CumulativeDist <= got[0].DistanceM {
+		t.Fatalf("got cumulative distance %.6f, want more than day one %.6f", got[2].CumulativeDist, got[0].DistanceM)
+	}
+}
+
+func TestForecastDaysThroughCountsToday(t *testing.T) {
+	now := time.Date(2026, 7, 1, 15, 30, 0, 0, time.UTC)
+	if got := forecastDaysThrough(now, now); got != 1 {
+		t.Fatalf("got %d forecast days for today, want 1", got)
+	}
+	if got := forecastDaysThrough(now.AddDate(0, 0, 3), now); got != 4 {
+		t.Fatalf("got %d forecast days, want 4", got)
+	}
+}
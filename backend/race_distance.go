@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// trackRaceResult is the race distance implied by repeating one simulated lap
+// until either the race window or the battery runs out.
+type trackRaceResult struct {
+	DistanceM         float64 `json:"distanceM"`
+	LapsCompleted     int     `json:"lapsCompleted"`
+	LapLengthM        float64 `json:"lapLengthM"`
+	LapTimeS          float64 `json:"lapTimeS"`
+	LapEnergyWh       float64 `json:"lapEnergyWh"` // drawn from the battery per lap, before solar
+	LapSolarWh        float64 `json:"lapSolarWh"`  // solar gained per lap
+	RemainingEnergyWh float64 `json:"remainingEnergyWh"`
+	LimitedBy         string  `json:"limitedBy"` // "time" or "battery"
+}
+
+// stepTractionForce returns the drive force [N] needed to go from v0 to v1
+// over ds: the kinetic energy change plus the PowerRequired resistances at
+// the step's mean speed. A negative result means the step is coasting or
+// braking and draws nothing from the battery.
+func stepTractionForce(v0, v1, ds float64, inputs simulationInputs) float64 {
+	vAvg := 0.5 * (v0 + v1)
+	fInertia := inputs.M * (v1*v1 - v0*v0) / (2 * ds)
+	fRes := 0.0
+	if vAvg > 0 {
+		fRes = PowerRequired(vAvg, inputs.M, inputs.G, inputs.Crr, inputs.Rho, inputs.Cd, inputs.A, inputs.Theta, inputs.AdditionalEfficiency) / vAvg
+	}
+	return fInertia + fRes
+}
+
+// lapTimeAndEnergy integrates a telemetry lap into elapsed time [s] and the
+// battery energy [Wh] it draws. Braking is treated as lost (no regen).
+func lapTimeAndEnergy(points []telemetryPoint, inputs simulationInputs) (float64, float64) {
+	timeS, wheelJ := 0.0, 0.0
+	for i := 1; i < len(points); i++ {
+		ds := points[i].Distance - points[i-1].Distance
+		if ds <= 0 {
+			continue
+		}
+		v0, v1 := points[i-1].Speed, points[i].Speed
+		vAvg := 0.5 * (v0 + v1)
+		if vAvg <= 0 {
+			continue
+		}
+		timeS += ds / vAvg
+
+		if f := stepTractionForce(v0, v1, ds, inputs); f > 0 {
+			wheelJ += f * ds
+		}
+	}
+	return timeS, wheelJ / inputs.EtaDrive / 3600.0
+}
+
+// trackRaceDistance repeats the simulated lap for the whole race window.
+// Every lap costs the same energy and time, so the lap count is whichever of
+// the time and battery budgets runs out first; the last lap may be partial.
+// The battery budget stops at the inputs reserve.
+func trackRaceDistance(points []telemetryPoint, inputs simulationInputs) (trackRaceResult, error) {
+	if len(points) < 2 || inputs.EtaDrive <= 0 {
+		return trackRaceResult{}, fmt.Errorf("telemetry lap produced no points")
+	}
+	lapLength := points[len(points)-1].Distance - points[0].Distance
+	lapTime, lapEnergy := lapTimeAndEnergy(points, inputs)
+	if lapLength <= 0 || lapTime <= 0 {
+		return trackRaceResult{}, fmt.Errorf("telemetry lap has no length")
+	}
+
+	raceS := inputs.RaceDayMin * 60.0
+	lapSolar := inputs.SolarWhPerMin * lapTime / 60.0
+	lapNet := lapEnergy - lapSolar
+
+	laps := raceS / lapTime
+	limitedBy := "time"
+	if lapNet > 0 {
+		if batteryLaps := inputs.usableBatteryWh() / lapNet; batteryLaps < laps {
+			laps = batteryLaps
+			limitedBy = "battery"
+		}
+	}
+
+	remaining := inputs.BatteryWh - laps*lapNet
+	if limitedBy == "battery" {
+		remaining = inputs.reserveWh()
+	}
+	remaining = math.Min(remaining, inputs.BatteryWh)
+
+	return trackRaceResult{
+		DistanceM:         laps * lapLength,
+		LapsCompleted:     int(math.Floor(laps + 1e-9)),
+		LapLengthM:        lapLength,
+		LapTimeS:          lapTime,
+		LapEnergyWh:       lapEnergy,
+		LapSolarWh:        lapSolar,
+		RemainingEnergyWh: remaining,
+		LimitedBy:         limitedBy,
+	}, nil
+}
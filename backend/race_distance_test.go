@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLapTimeAndEnergyAtConstantSpeedMatchesPowerRequired(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	const v = 20.0
+	points := []telemetryPoint{
+		{Speed: v, Distance: 0},
+		{Speed: v, Distance: 500},
+		{Speed: v, Distance: 1000},
+	}
+
+	gotTime, gotWh := lapTimeAndEnergy(points, inputs)
+	wantTime := 1000 / v
+	wantWh := PowerRequired(v, inputs.M, inputs.G, inputs.Crr, inputs.Rho, inputs.Cd, inputs.A, inputs.Theta, inputs.AdditionalEfficiency) *
+		wantTime / inputs.EtaDrive / 3600
+	if math.Abs(gotTime-wantTime) > 1e-9 {
+		t.Fatalf("got lap time %.6f s, want %.6f", gotTime, wantTime)
+	}
+	if math.Abs(gotWh-wantWh) > 1e-9 {
+		t.Fatalf("got lap energy %.6f Wh, want %.6f", gotWh, wantWh)
+	}
+}
+
+func TestTrackRaceDistanceStopsWhenBatteryRunsOut(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.SolarWhPerMin = 0
+	points := []telemetryPoint{{Speed: 20, Distance: 0}, {Speed: 20, Distance: 1000}}
+	_, lapWh := lapTimeAndEnergy(points, inputs)
+	inputs.BatteryWh = 2.5 * lapWh
+
+	got, err := trackRaceDistance(points, inputs)
+	if err != nil {
+		t.Fatalf("trackRaceDistance returned error: %v", err)
+	}
+	if got.LimitedBy != "battery" || got.LapsCompleted != 2 || got.RemainingEnergyWh != 0 {
+		t.Fatalf("got %+v, want 2 laps limited by battery with nothing left", got)
+	}
+	if math.Abs(got.DistanceM-2500) > 1e-6 {
+		t.Fatalf("got distance %.6f m, want 2500", got.DistanceM)
+	}
+}
+
+func TestTrackRaceDistanceOnDefaultTrackIsBelowCruiseEstimate(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = computeOptimalSpeedForInputs(inputs)
+	cruise, ok := distanceForInputs(inputs)
+	if !ok {
+		t.Fatal("expected cruise distance to be feasible")
+	}
+	points, err := buildTelemetryForInputs(defaultTrackSegments(), true, inputs)
+	if err != nil {
+		t.Fatalf("buildTelemetryForInputs returned error: %v", err)
+	}
+
+	got, err := trackRaceDistance(points, inputs)
+	if err != nil {
+		t.Fatalf("trackRaceDistance returned error: %v", err)
+	}
+	if got.DistanceM <= 0 || got.DistanceM >= cruise {
+		t.Fatalf("got track distance %.3f m, want positive and below cruise estimate %.3f m", got.DistanceM, cruise)
+	}
+}
+
+func TestTrackRaceDistanceStopsAtBatteryReserve(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.SolarWhPerMin = 0
+	points := []telemetryPoint{{Speed: 20, Distance: 0}, {Speed: 20, Distance: 1000}}
+	_, lapWh := lapTimeAndEnergy(points, inputs)
+	inputs.BatteryWh = 3 * lapWh
+	inputs.MinReserveWh = lapWh
+
+	got, err := trackRaceDistance(points, inputs)
+	if err != nil {
+		t.Fatalf("trackRaceDistance returned error: %v", err)
+	}
+	if got.LimitedBy != "battery" || got.LapsCompleted != 2 || math.Abs(got.RemainingEnergyWh-lapWh) > 1e-9 {
+		t.Fatalf("got %+v, want 2 laps limited by battery with the reserve left", got)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// raceSolarPower returns the solar charging power [W] at elapsedS seconds
+// after the race start.
+type raceSolarPower func(elapsedS float64) float64
+
+// constantSolarPower spreads inputs.SolarWhPerMin evenly over the race.
+func constantSolarPower(inputs simulationInputs) raceSolarPower {
+	powerW := inputs.SolarWhPerMin * 60.0
+	return func(float64) float64 { return powerW }
+}
+
+// hourlySolarPower linearly interpolates an hourly power series (such as
+// windowHourlyPower) at raceStart+elapsed, holding the end values outside it.
+func hourlySolarPower(raceStart time.Time, times []time.Time, powerW []float64) raceSolarPower {
+	n := min(len(times), len(powerW))
+	return func(elapsedS float64) float64 {
+		if n == 0 {
+			return 0
+		}
+		at := raceStart.Add(time.Duration(elapsedS * float64(time.Second)))
+		i := sort.Search(n, func(i int) bool { return !times[i].Before(at) })
+		if i == 0 {
+			return powerW[0]
+		}
+		if i >= n {
+			return powerW[n-1]
+		}
+		span := times[i].Sub(times[i-1]).Seconds()
+		if span <= 0 {
+			return powerW[i]
+		}
+		frac := at.Sub(times[i-1]).Seconds() / span
+		return powerW[i-1] + frac*(powerW[i]-powerW[i-1])
+	}
+}
+
+// solarEnergyBetween integrates solar power over [t0, t1] seconds with the
+// trapezoid rule on one-minute steps.
+func solarEnergyBetween(solar raceSolarPower, t0, t1 float64) float64 {
+	if t1 <= t0 {
+		return 0
+	}
+	const stepS = 60.0
+	energyJ := 0.0
+	for t := t0; t < t1; t += stepS {
+		dt := math.Min(stepS, t1-t)
+		energyJ += 0.5 * (solar(t) + solar(t+dt)) * dt
+	}
+	return energyJ / 3600.0
+}
+
+// lapRecord is one row of the race table, taken as the car crosses the line.
+type lapRecord struct {
+	Lap         int     `json:"lap"`
+	StartS      float64 `json:"startS"` // race clock at the start of the lap
+	LapTimeS    float64 `json:"lapTimeS"`
+	EnergyWh    float64 `json:"energyWh"` // drawn from the battery by the drivetrain
+	SolarWh     float64 `json:"solarWh"`  // gained from the array during the lap
+	BatteryWh   float64 `json:"batteryWh"`
+	SOC         float64 `json:"soc"` // % of inputs.BatteryWh at the line
+	AvgSpeedMPS float64 `json:"avgSpeedMps"`
+
+	Losses energyBreakdown `json:"losses"`
+}
+
+// raceLapsResult is the whole-race table plus how the race ended.
+type raceLapsResult struct {
+	Laps              []lapRecord `json:"laps"`
+	DistanceM         float64     `json:"distanceM"`
+	ElapsedS          float64     `json:"elapsedS"`
+	RemainingEnergyWh float64     `json:"remainingEnergyWh"`
+	LimitedBy         string      `json:"limitedBy"` // "time" or "battery"
+}
+
+// simulateRaceLaps drives consecutive laps for the whole inputs.RaceDayMin
+// window. Each lap starts at the previous lap's terminal speed, the battery
+// starts full at inputs.BatteryWh and is charged by solar as the race clock
+// runs. The race ends at the last lap that finishes inside the window without
+// dipping into the battery reserve.
+func simulateRaceLaps(segments []trackSegment, inputs simulationInputs, solar raceSolarPower) (raceLapsResult, error) {
+	return simulateRaceLapsFrom(segments, inputs, solar, inputs.BatteryWh)
+}
+
+// maxRaceWindowMin bounds the lap-by-lap race window; the lap loop and its
+// table grow with it.
+const maxRaceWindowMin = 24 * 60
+
+// simulateRaceLapsFrom is simulateRaceLaps starting from startBatteryWh
+// instead of a full pack; inputs.BatteryWh stays the pack capacity.
+func simulateRaceLapsFrom(segments []trackSegment, inputs simulationInputs, solar raceSolarPower, startBatteryWh float64) (raceLapsResult, error) {
+	if inputs.RaceDayMin <= 0 || inputs.BatteryWh <= 0 || startBatteryWh < 0 {
+		return raceLapsResult{}, fmt.Errorf("missing or invalid input values")
+	}
+	if inputs.RaceDayMin > maxRaceWindowMin {
+		return raceLapsResult{}, fmt.Errorf("race window must be at most %d minutes", maxRaceWindowMin)
+	}
+	if solar == nil {
+		solar = constantSolarPower(inputs)
+	}
+
+	raceS := inputs.RaceDayMin * 60.0
+	capacityWh := inputs.BatteryWh
+	batteryWh := math.Min(capacityWh, startBatteryWh)
+	reserveWh := inputs.reserveWh()
+	result := raceLapsResult{LimitedBy: "time"}
+
+	startSpeed := defaultTelemetryStartSpeed
+	var points []telemetryPoint
+	var losses energyBreakdown
+	cachedStart := math.NaN()
+	elapsed := 0.0
+	for lap := 1; ; lap++ {
+		// once the start speed settles every lap is identical, so reuse it
+		if startSpeed != cachedStart {
+			var err error
+			points, err = buildTelemetryOneLapForInputs(segments, startSpeed, inputs)
+			if err != nil {
+				return raceLapsResult{}, err
+			}
+			losses, _ = lapEnergyBreakdown(points, segments, inputs)
+			cachedStart = startSpeed
+		}
+		lapTime, energyWh := lapTimeAndEnergy(points, inputs)
+		if lapTime <= 0 {
+			return raceLapsResult{}, fmt.Errorf("telemetry lap has no length")
+		}
+		if elapsed+lapTime > raceS {
+			break
+		}
+
+		solarWh := solarEnergyBetween(solar, elapsed, elapsed+lapTime)
+		next := batteryWh - energyWh + solarWh
+		if next < reserveWh {
+			result.LimitedBy = "battery"
+			break
+		}
+		batteryWh = math.Min(capacityWh, next)
+
+		lapLength := points[len(points)-1].Distance - points[0].Distance
+		result.Laps = append(result.Laps, lapRecord{
+			Lap:         lap,
+			StartS:      elapsed,
+			LapTimeS:    lapTime,
+			EnergyWh:    energyWh,
+			SolarWh:     solarWh,
+			BatteryWh:   batteryWh,
+			SOC:         batteryWh / capacityWh * 100,
+			AvgSpeedMPS: lapLength / lapTime,
+			Losses:      losses,
+		})
+		result.DistanceM += lapLength
+		elapsed += lapTime
+
+		terminal, err := telemetryTerminalSpeed(points)
+		if err != nil {
+			return raceLapsResult{}, err
+		}
+		startSpeed = terminal
+	}
+
+	result.ElapsedS = elapsed
+	result.RemainingEnergyWh = batteryWh
+	return result, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulateRaceLapsCarriesBatteryBetweenLaps(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = computeOptimalSpeedForInputs(inputs)
+
+	got, err := simulateRaceLaps(defaultTrackSegments(), inputs, nil)
+	if err != nil {
+		t.Fatalf("simulateRaceLaps returned error: %v", err)
+	}
+	if len(got.Laps) == 0 {
+		t.Fatal("expected completed laps")
+	}
+
+	battery := inputs.BatteryWh
+	for _, lap := range got.Laps {
+		battery = math.Min(inputs.BatteryWh, battery-lap.EnergyWh+lap.SolarWh)
+		if math.Abs(lap.BatteryWh-battery) > 1e-6 {
+			t.Fatalf("lap %d: got battery %.6f Wh, want %.6f", lap.Lap, lap.BatteryWh, battery)
+		}
+		if math.Abs(lap.SOC-battery/inputs.BatteryWh*100) > 1e-9 {
+			t.Fatalf("lap %d: got SOC %.6f, want %.6f", lap.Lap, lap.SOC, battery/inputs.BatteryWh*100)
+		}
+	}
+	if got.ElapsedS > inputs.RaceDayMin*60 {
+		t.Fatalf("got elapsed %.3f s, want within race window %.3f s", got.ElapsedS, inputs.RaceDayMin*60)
+	}
+}
+
+func TestSimulateRaceLapsStopsWhenBatteryIsEmpty(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.BatteryWh = 200
+	inputs.SolarWhPerMin = 0
+	// well above the ~4.3 m/s that would stretch 200 Wh over the whole race
+	inputs.V = 10
+
+	got, err := simulateRaceLaps(defaultTrackSegments(), inputs, nil)
+	if err != nil {
+		t.Fatalf("simulateRaceLaps returned error: %v", err)
+	}
+	if got.LimitedBy != "battery" {
+		t.Fatalf("got limitedBy %q, want battery", got.LimitedBy)
+	}
+	if got.RemainingEnergyWh < 0 {
+		t.Fatalf("got remaining energy %.6f Wh, want non-negative", got.RemainingEnergyWh)
+	}
+}
+
+func TestHourlySolarPowerInterpolatesByClock(t *testing.T) {
+	start := time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)
+	times := []time.Time{start, start.Add(time.Hour)}
+	solar := hourlySolarPower(start, times, []float64{400, 800})
+
+	if got := solar(1800); math.Abs(got-600) > 1e-9 {
+		t.Fatalf("got %.6f W half an hour in, want 600", got)
+	}
+	if got := solar(7200); got != 800 {
+		t.Fatalf("got %.6f W past the series, want 800", got)
+	}
+	if got := solarEnergyBetween(solar, 0, 3600); math.Abs(got-600) > 1e-9 {
+		t.Fatalf("got %.6f Wh over the first hour, want 600", got)
+	}
+}
+
+func TestRaceLapsHandlerReturnsLapTable(t *testing.T) {
+	body, err := json.Marshal(raceLapsRequest{Inputs: defaultSimulationInputs()})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/race/laps", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	raceLapsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got raceLapsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || len(got.Laps) == 0 || got.Laps[0].Lap != 1 {
+		t.Fatalf("got ok=%v laps=%d, want a lap table starting at lap 1", got.OK, len(got.Laps))
+	}
+}
+
+func TestRaceLapsHandlerFillsSiteFromTheDefault(t *testing.T) {
+	day := time.Now().UTC()
+	stubHourlyGTI(t, day, time.UTC, 1, 0)
+	if defaultSimulationInputs().SolarWhPerMin <= 0 {
+		t.Fatal("test needs default inputs with constant solar")
+	}
+
+	// only the timezone is given; the array comes from defaultSolarSite
+	body := `{"site":{"timezone":"UTC"},"startTime":"` + day.Format("2006-01-02") + `T09:00"}`
+	req := httptest.NewRequest(http.MethodPost, "/race/laps", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	raceLapsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got raceLapsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if len(got.Laps) == 0 || got.Laps[0].SolarWh != 0 {
+		t.Fatalf("got %d laps, want solar from the stubbed dark forecast", len(got.Laps))
+	}
+}
+
+func TestRaceLapsHandlerRejectsOverlongRaceWindow(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.RaceDayMin = 2000000
+	body, err := json.Marshal(raceLapsRequest{Inputs: inputs})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/race/laps", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	raceLapsHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
+
+func TestSimulateRaceLapsKeepsBatteryReserve(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.BatteryWh = 400
+	inputs.MinReserveWh = 150
+	inputs.SolarWhPerMin = 0
+	inputs.V = 10
+
+	got, err := simulateRaceLaps(defaultTrackSegments(), inputs, nil)
+	if err != nil {
+		t.Fatalf("simulateRaceLaps returned error: %v", err)
+	}
+	if got.LimitedBy != "battery" {
+		t.Fatalf("got limitedBy %q, want battery", got.LimitedBy)
+	}
+	for _, lap := range got.Laps {
+		if lap.BatteryWh < inputs.MinReserveWh {
+			t.Fatalf("lap %d: got battery %.3f Wh, want at least the %.0f Wh reserve", lap.Lap, lap.BatteryWh, inputs.MinReserveWh)
+		}
+	}
+}
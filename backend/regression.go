@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// z95 is the two-sided 95% normal quantile used for confidence intervals.
+// Calibration logs have hundreds of samples, so the t quantile is the same
+// to two decimals.
+const z95 = 1.959964
+
+// linearFit is an ordinary least-squares fit y ≈ X·Coef.
+type linearFit struct {
+	Coef []float64
+	Cov  [][]float64 // coefficient covariance, σ²(XᵀX)⁻¹
+	RSS  float64     // residual sum of squares
+	TSS  float64     // total sum of squares about the mean of y
+	N    int
+}
+
+// rmse is the root-mean-square residual.
+func (f linearFit) rmse() float64 {
+	if f.N == 0 {
+		return 0
+	}
+	return math.Sqrt(f.RSS / float64(f.N))
+}
+
+// r2 is the coefficient of determination.
+func (f linearFit) r2() float64 {
+	if f.TSS == 0 {
+		return 1
+	}
+	return 1 - f.RSS/f.TSS
+}
+
+// fitLeastSquares solves the normal equations for a small number of columns.
+func fitLeastSquares(x [][]float64, y []float64) (linearFit, error) {
+	n := len(y)
+	if n == 0 || len(x) != n {
+		return linearFit{}, fmt.Errorf("regression needs one row per observation")
+	}
+	p := len(x[0])
+	if n <= p {
+		return linearFit{}, fmt.Errorf("regression needs more than %d observations, got %d", p, n)
+	}
+
+	xtx := make([][]float64, p)
+	xty := make([]float64, p)
+	for i := range xtx {
+		xtx[i] = make([]float64, p)
+	}
+	for r, row := range x {
+		for i := 0; i < p; i++ {
+			xty[i] += row[i] * y[r]
+			for j := 0; j < p; j++ {
+				xtx[i][j] += row[i] * row[j]
+			}
+		}
+	}
+	inv, err := invertMatrix(xtx)
+	if err != nil {
+		return linearFit{}, fmt.Errorf("regression is degenerate: %w", err)
+	}
+
+	fit := linearFit{Coef: make([]float64, p), N: n}
+	for i := 0; i < p; i++ {
+		for j := 0; j < p; j++ {
+			fit.Coef[i] += inv[i][j] * xty[j]
+		}
+	}
+	mean := 0.0
+	for _, v := range y {
+		mean += v / float64(n)
+	}
+	for r, row := range x {
+		pred := 0.0
+		for i := 0; i < p; i++ {
+			pred += row[i] * fit.Coef[i]
+		}
+		fit.RSS += (y[r] - pred) * (y[r] - pred)
+		fit.TSS += (y[r] - mean) * (y[r] - mean)
+	}
+	sigma2 := fit.RSS / float64(n-p)
+	fit.Cov = make([][]float64, p)
+	for i := range fit.Cov {
+		fit.Cov[i] = make([]float64, p)
+		for j := range fit.Cov[i] {
+			fit.Cov[i][j] = sigma2 * inv[i][j]
+		}
+	}
+	return fit, nil
+}
+
+// invertMatrix inverts a square matrix by Gauss-Jordan elimination with
+// partial pivoting.
+func invertMatrix(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = make([]float64, 2*n)
+		copy(m[i], a[i])
+		m[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		scale := m[col][col]
+		for j := range m[col] {
+			m[col][j] /= scale
+		}
+		for r := 0; r < n; r++ {
+			if r == col || m[r][col] == 0 {
+				continue
+			}
+			f := m[r][col]
+			for j := range m[r] {
+				m[r][j] -= f * m[col][j]
+			}
+		}
+	}
+	inv := make([][]float64, n)
+	for i := range m {
+		inv[i] = m[i][n:]
+	}
+	return inv, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// replanResult is the strategy for the rest of the race from the car's
+// current state. Projections count from the moment of the request.
+type replanResult struct {
+	RemainingMin    float64        `json:"remainingMin"`
+	StartBatteryWh  float64        `json:"startBatteryWh"`
+	StartSOC        float64        `json:"startSoc"`
+	SolarWh         float64        `json:"solarWh"` // forecast over the remaining window
+	TargetV         float64        `json:"targetV"` // m/s, optimal cruise for the rest of the race
+	SpeedSearch     optimizeResult `json:"speedSearch"`
+	CruiseDistanceM float64        `json:"cruiseDistanceM"` // constant-speed estimate of the remaining distance
+
+	// lap simulation of the remaining window at TargetV
+	LapsDone           float64     `json:"lapsDone"`
+	RemainingLaps      int         `json:"remainingLaps"`
+	ProjectedLaps      float64     `json:"projectedLaps"` // LapsDone + RemainingLaps
+	ProjectedDistanceM float64     `json:"projectedDistanceM"`
+	FinishElapsedMin   float64     `json:"finishElapsedMin"` // from now to the last lap across the line
+	FinishBatteryWh    float64     `json:"finishBatteryWh"`
+	FinishSOC          float64     `json:"finishSoc"`
+	LimitedBy          string      `json:"limitedBy"`
+	Laps               []lapRecord `json:"laps"`
+}
+
+// replanRace re-solves the cruise speed for the remaining remainingMin
+// minutes starting from batteryWh, with solar from the given curve (nil
+// means the constant inputs.SolarWhPerMin), then simulates the remaining
+// laps at that speed. inputs.BatteryWh stays the pack capacity and the
+// reserve is still a share of it, as in simulateRaceDay.
+func replanRace(inputs simulationInputs, solar raceSolarPower, remainingMin, batteryWh, lapsDone float64) (replanResult, error) {
+	if remainingMin <= 0 {
+		return replanResult{}, fmt.Errorf("remaining race window must be positive")
+	}
+	if remainingMin > maxRaceWindowMin {
+		return replanResult{}, fmt.Errorf("remaining race window must be at most %d minutes", maxRaceWindowMin)
+	}
+	if batteryWh < 0 || batteryWh > inputs.BatteryWh {
+		return replanResult{}, fmt.Errorf("current battery must be between 0 and batteryWh")
+	}
+	if lapsDone < 0 {
+		return replanResult{}, fmt.Errorf("laps done must be >= 0")
+	}
+	if solar == nil {
+		solar = constantSolarPower(inputs)
+	}
+	remainingS := remainingMin * 60.0
+	result := replanResult{
+		RemainingMin:   remainingMin,
+		StartBatteryWh: batteryWh,
+		StartSOC:       batteryWh / inputs.BatteryWh * 100,
+		SolarWh:        solarEnergyBetween(solar, 0, remainingS),
+		LapsDone:       lapsDone,
+	}
+
+	rest := inputs
+	rest.MinReserveWh, rest.MinReservePct = inputs.reserveWh(), 0
+	rest.BatteryWh = batteryWh
+	rest.RaceDayMin = remainingMin
+	rest.SolarWhPerMin = result.SolarWh / remainingMin
+	search, err := optimalSpeedSearch(rest, defaultSpeedSearchOptions())
+	if err != nil {
+		return replanResult{}, fmt.Errorf("no feasible cruise speed for the remaining race")
+	}
+	rest.V = search.X
+	result.TargetV, result.SpeedSearch = search.X, search
+	result.CruiseDistanceM, _ = distanceForInputs(rest)
+
+	laps := inputs
+	laps.RaceDayMin = remainingMin
+	laps.V = result.TargetV
+	race, err := simulateRaceLapsFrom(defaultTrackSegments(), laps, solar, batteryWh)
+	if err != nil {
+		return replanResult{}, err
+	}
+	result.RemainingLaps = len(race.Laps)
+	result.ProjectedLaps = lapsDone + float64(result.RemainingLaps)
+	result.ProjectedDistanceM = race.DistanceM
+	result.FinishElapsedMin = race.ElapsedS / 60
+	result.FinishBatteryWh = race.RemainingEnergyWh
+	result.FinishSOC = math.Max(race.RemainingEnergyWh, 0) / inputs.BatteryWh * 100
+	result.LimitedBy = race.LimitedBy
+	result.Laps = race.Laps
+	return result, nil
+}
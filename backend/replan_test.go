@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplanRaceAtStartMatchesOptimalSpeed(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	got, err := replanRace(inputs, nil, inputs.RaceDayMin, inputs.BatteryWh, 0)
+	if err != nil {
+		t.Fatalf("replanRace returned error: %v", err)
+	}
+	if want := computeOptimalSpeedForInputs(inputs); math.Abs(got.TargetV-want) > 1e-6 {
+		t.Fatalf("got target %.4f m/s, want the full-race optimum %.4f", got.TargetV, want)
+	}
+	if got.RemainingLaps == 0 || got.ProjectedDistanceM <= 0 || got.StartSOC != 100 {
+		t.Fatalf("got %d laps %.0f m from %.1f%%, want a full race from a full pack", got.RemainingLaps, got.ProjectedDistanceM, got.StartSOC)
+	}
+}
+
+func TestReplanRaceSlowsDownWhenShortOfEnergy(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	onPlan, err := replanRace(inputs, nil, 240, 0.5*inputs.BatteryWh, 37)
+	if err != nil {
+		t.Fatalf("replanRace returned error: %v", err)
+	}
+	short, err := replanRace(inputs, nil, 240, 0.2*inputs.BatteryWh, 37)
+	if err != nil {
+		t.Fatalf("replanRace returned error: %v", err)
+	}
+	if short.TargetV >= onPlan.TargetV {
+		t.Fatalf("got %.3f m/s with 20%% left and %.3f m/s with 50%%, want slower when short", short.TargetV, onPlan.TargetV)
+	}
+	if short.ProjectedLaps < 37 || short.FinishElapsedMin > 240 {
+		t.Fatalf("got %.1f laps finishing at %.1f min, want at least the 37 done inside the window", short.ProjectedLaps, short.FinishElapsedMin)
+	}
+}
+
+func TestReplanHandlerUsesRaceEnd(t *testing.T) {
+	soc := 42.0
+	body, err := json.Marshal(replanRequest{Inputs: defaultSimulationInputs(), CurrentTime: "2026-06-01T14:15", RaceEnd: "2026-06-01T17:00", SOC: &soc, LapsDone: 37})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/replan", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	replanHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got replanResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || got.RemainingMin != 165 || got.StartSOC != 42 || got.TargetV <= 0 || got.LapsDone != 37 {
+		t.Fatalf("got %+v, want 165 min from 42%% after 37 laps", got.replanResult)
+	}
+}
+
+func TestReplanHandlerNeedsBatteryState(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/replan", strings.NewReader(`{"remainingMin":60}`))
+	rec := httptest.NewRecorder()
+
+	replanHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestReplanHandlerRejectsOverlongRaceWindow(t *testing.T) {
+	soc := 80.0
+	body, err := json.Marshal(replanRequest{Inputs: defaultSimulationInputs(), CurrentTime: "2026-06-01T09:00", RaceEnd: "2030-06-01T17:00", SOC: &soc})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/replan", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	replanHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
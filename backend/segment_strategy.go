@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// segmentStrategyStepMPS is the speed grid the segment optimizer chooses from.
+const segmentStrategyStepMPS = 0.5
+
+// segmentTarget is the planned speed for one track segment.
+type segmentTarget struct {
+	Segment     int     `json:"segment"`
+	Type        string  `json:"type"`
+	LengthM     float64 `json:"lengthM"`
+	TargetSpeed float64 `json:"targetSpeed"` // m/s; the car may be slower where braking or grip demands
+}
+
+// segmentStrategyResult is a variable-speed plan and how it compares with
+// holding the single optimal cruise speed.
+type segmentStrategyResult struct {
+	Plan              []segmentTarget  `json:"plan"`
+	Race              trackRaceResult  `json:"race"`
+	ConstantV         float64          `json:"constantV"`
+	ConstantDistanceM float64          `json:"constantDistanceM"`
+	TimeWeightWhPerS  float64          `json:"timeWeightWhPerS"` // λ of the DP plan; 0 when built from the constant plan or scaled
+	Points            []telemetryPoint `json:"points"`
+}
+
+// segmentSpeedCaps returns each segment's length and the highest speed the
+// optimizer may pick on it: the telemetry ceiling, lowered by the curve cap
+// sqrt(gmax*g*r) on curves.
+func segmentSpeedCaps(segments []trackSegment, inputs simulationInputs) ([]float64, []float64) {
+	lengths := make([]float64, len(segments))
+	caps := make([]float64, len(segments))
+	for i, seg := range segments {
+		lengths[i] = getTotalLength(telemetryTrackFromSegments([]trackSegment{seg}))
+		caps[i] = telemetryMaxSpeed
+		if seg.Type == "curve" && seg.Radius > 0 {
+			caps[i] = math.Min(caps[i], calcCurveSpeed(Segment{Radius: seg.Radius}, inputs.G, inputs.Gmax))
+		}
+	}
+	return lengths, caps
+}
+
+// planSegmentSpeeds picks one speed per segment minimising
+//
+//	battery energy [Wh] + timeWeight * lap time [s]
+//
+// by dynamic programming over the speed grid. Each segment costs its cruise
+// energy and time at the chosen speed; speeding up between segments costs the
+// kinetic energy through the drivetrain, slowing down is free (the energy is
+// lost to coasting or braking). The lap is closed by charging the transition
+// from the last segment back into the first, using entrySpeed as the last
+// segment's speed on the first pass.
+func planSegmentSpeeds(lengths, caps []float64, inputs simulationInputs, timeWeight, entrySpeed float64) []float64 {
+	n := len(lengths)
+	if n == 0 {
+		return nil
+	}
+	grid := make([]float64, 0, int(telemetryMaxSpeed/segmentStrategyStepMPS))
+	for v := 2.0; v <= telemetryMaxSpeed+1e-9; v += segmentStrategyStepMPS {
+		grid = append(grid, v)
+	}
+
+	cruiseCost := func(seg, k int) float64 {
+		v := grid[k]
+		if v > caps[seg]+1e-9 || lengths[seg] <= 0 {
+			return math.Inf(1)
+		}
+		p, ok := cruiseBatteryPowerW(v, inputs)
+		if !ok {
+			return math.Inf(1)
+		}
+		t := lengths[seg] / v
+		return p*t/3600.0 + timeWeight*t
+	}
+	transitionCost := func(from, to float64) float64 {
+		if to <= from {
+			return 0
+		}
+		return 0.5 * inputs.M * (to*to - from*from) / inputs.EtaDrive / 3600.0
+	}
+
+	cost := make([][]float64, n)
+	prev := make([][]int, n)
+	for i := range cost {
+		cost[i] = make([]float64, len(grid))
+		prev[i] = make([]int, len(grid))
+	}
+	for k := range grid {
+		cost[0][k] = cruiseCost(0, k) + transitionCost(entrySpeed, grid[k])
+		prev[0][k] = -1
+	}
+	for i := 1; i < n; i++ {
+		for k := range grid {
+			here := cruiseCost(i, k)
+			best, bestJ := math.Inf(1), -1
+			if !math.IsInf(here, 1) {
+				for j := range grid {
+					if c := cost[i-1][j] + transitionCost(grid[j], grid[k]); c < best {
+						best, bestJ = c, j
+					}
+				}
+			}
+			cost[i][k] = best + here
+			prev[i][k] = bestJ
+		}
+	}
+
+	bestK, best := -1, math.Inf(1)
+	for k := range grid {
+		if c := cost[n-1][k]; c < best {
+			best, bestK = c, k
+		}
+	}
+	if bestK < 0 {
+		return nil
+	}
+	plan := make([]float64, n)
+	for i, k := n-1, bestK; i >= 0; i-- {
+		plan[i] = grid[k]
+		k = prev[i][k]
+	}
+	return plan
+}
+
+// evaluateSpeedPlan runs the telemetry lap with plan and scores it as a race.
+func evaluateSpeedPlan(segments []trackSegment, inputs simulationInputs, plan []float64) (trackRaceResult, []telemetryPoint, error) {
+	inputs.SpeedPlan = plan
+	inputs.V = telemetryMaxSpeed
+	points, err := buildTelemetryForInputs(segments, true, inputs)
+	if err != nil {
+		return trackRaceResult{}, nil, err
+	}
+	race, err := trackRaceDistance(points, inputs)
+	return race, points, err
+}
+
+// closedLapPlan is planSegmentSpeeds for λ entering at entryV, then again
+// entering at the first pass's final speed so the lap closes on itself.
+func closedLapPlan(lengths, caps []float64, inputs simulationInputs, lambda, entryV float64) []float64 {
+	plan := planSegmentSpeeds(lengths, caps, inputs, lambda, entryV)
+	if len(plan) == 0 {
+		return nil
+	}
+	return planSegmentSpeeds(lengths, caps, inputs, lambda, plan[len(plan)-1])
+}
+
+// optimizeSegmentSpeeds searches the time weight λ of planSegmentSpeeds for the
+// plan that covers the most race distance when run through the lap
+// simulation. Large λ favours lap time (time-limited races), small λ favours
+// net energy after solar (battery-limited races). The constant-speed plan is
+// kept whenever it is at least as good, so the result never does worse than OptimalV.
+func optimizeSegmentSpeeds(segments []trackSegment, inputs simulationInputs) (segmentStrategyResult, error) {
+	lengths, caps := segmentSpeedCaps(segments, inputs)
+	if len(lengths) == 0 {
+		return segmentStrategyResult{}, fmt.Errorf("track has no segments")
+	}
+
+	constant := inputs
+	constant.V = computeOptimalSpeedForInputs(inputs)
+	constantPlan := make([]float64, len(segments))
+	for i := range constantPlan {
+		constantPlan[i] = math.Min(constant.V, caps[i])
+	}
+	bestRace, bestPoints, err := evaluateSpeedPlan(segments, inputs, constantPlan)
+	if err != nil {
+		return segmentStrategyResult{}, err
+	}
+	result := segmentStrategyResult{ConstantV: constant.V, ConstantDistanceM: bestRace.DistanceM}
+	bestPlan := constantPlan
+
+	planFor := func(lambda float64) []float64 {
+		return closedLapPlan(lengths, caps, inputs, lambda, constant.V)
+	}
+	try := func(plan []float64) (trackRaceResult, bool) {
+		race, points, err := evaluateSpeedPlan(segments, inputs, plan)
+		if err != nil {
+			return trackRaceResult{}, false
+		}
+		if race.DistanceM > bestRace.DistanceM {
+			bestRace, bestPoints, bestPlan = race, points, plan
+		}
+		return race, true
+	}
+	score := func(lambda float64) (trackRaceResult, bool) {
+		plan := planFor(lambda)
+		if plan == nil {
+			return trackRaceResult{}, false
+		}
+		before := bestRace.DistanceM
+		race, ok := try(plan)
+		if bestRace.DistanceM > before {
+			result.TimeWeightWhPerS = lambda
+		}
+		return race, ok
+	}
+
+	// Solar pays back S Wh for every second on track, so a battery-limited
+	// race wants λ down to -S (minimise E - S*T per lap). Raising λ trades
+	// energy for pace: distance grows while the race stays time-limited and
+	// falls once the battery runs out first, so the best plan sits where the
+	// two budgets cross. Scan log(λ + S) coarsely, then bisect the first
+	// time-to-battery crossing.
+	solarWhPerS := inputs.SolarWhPerMin / 60.0
+	lambdaAt := func(x float64) float64 { return math.Exp(x) - solarWhPerS }
+	const scanPoints = 32
+	xMin, xMax := math.Log(1e-4), math.Log(10.0)
+	step := (xMax - xMin) / (scanPoints - 1)
+	prevTimeX := math.NaN()
+	for i := 0; i < scanPoints; i++ {
+		x := xMin + float64(i)*step
+		race, ok := score(lambdaAt(x))
+		if !ok {
+			continue
+		}
+		if race.LimitedBy == "time" {
+			prevTimeX = x
+			continue
+		}
+		if math.IsNaN(prevTimeX) {
+			continue
+		}
+		lo, hi := prevTimeX, x
+		for j := 0; j < 24; j++ {
+			mid := 0.5 * (lo + hi)
+			race, ok := score(lambdaAt(mid))
+			if ok && race.LimitedBy == "time" {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		break
+	}
+
+	// The speed grid makes plans jump between λ values, so a time-limited
+	// best plan may still leave energy in the pack. Scale it up (within the
+	// segment caps) until the battery just lasts the race. A scaled plan is
+	// no longer the DP plan for any λ, so it reports none.
+	if bestRace.LimitedBy == "time" && bestRace.RemainingEnergyWh > inputs.reserveWh() {
+		before := bestRace.DistanceM
+		base := bestPlan
+		scaled := func(f float64) []float64 {
+			plan := make([]float64, len(base))
+			for i, v := range base {
+				plan[i] = math.Min(v*f, caps[i])
+			}
+			return plan
+		}
+		slowest := telemetryMaxSpeed
+		for _, v := range base {
+			slowest = math.Min(slowest, v)
+		}
+		lo, hi := 1.0, telemetryMaxSpeed/math.Max(slowest, 1)
+		for j := 0; j < 24; j++ {
+			mid := 0.5 * (lo + hi)
+			if race, ok := try(scaled(mid)); ok && race.LimitedBy == "time" {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		if bestRace.DistanceM > before {
+			result.TimeWeightWhPerS = 0
+		}
+	}
+
+	result.Race = bestRace
+	result.Points = bestPoints
+	result.Plan = make([]segmentTarget, len(segments))
+	for i, seg := range segments {
+		result.Plan[i] = segmentTarget{Segment: i, Type: seg.Type, LengthM: lengths[i], TargetSpeed: bestPlan[i]}
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlanSegmentSpeedsRespectsCurveCaps(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	segments := defaultTrackSegments()
+	lengths, caps := segmentSpeedCaps(segments, inputs)
+
+	plan := planSegmentSpeeds(lengths, caps, inputs, 1.0, 20)
+	if len(plan) != len(segments) {
+		t.Fatalf("got %d plan entries, want %d", len(plan), len(segments))
+	}
+	for i, v := range plan {
+		if v > caps[i]+1e-9 {
+			t.Fatalf("segment %d: got target %.3f m/s above cap %.3f", i, v, caps[i])
+		}
+	}
+}
+
+func TestPlanSegmentSpeedsFavoursPaceWithLargerTimeWeight(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	lengths, caps := segmentSpeedCaps(defaultTrackSegments(), inputs)
+
+	slow := planSegmentSpeeds(lengths, caps, inputs, 0.05, 20)
+	fast := planSegmentSpeeds(lengths, caps, inputs, 1.0, 20)
+	lapTime := func(plan []float64) float64 {
+		total := 0.0
+		for i, v := range plan {
+			total += lengths[i] / v
+		}
+		return total
+	}
+	if lapTime(fast) >= lapTime(slow) {
+		t.Fatalf("got lap time %.3f s with the larger weight, want below %.3f s", lapTime(fast), lapTime(slow))
+	}
+}
+
+func TestOptimizeSegmentSpeedsBeatsConstantSpeed(t *testing.T) {
+	for _, batteryWh := range []float64{1500, 5000} {
+		inputs := defaultSimulationInputs()
+		inputs.BatteryWh = batteryWh
+
+		got, err := optimizeSegmentSpeeds(defaultTrackSegments(), inputs)
+		if err != nil {
+			t.Fatalf("battery %.0f Wh: optimizeSegmentSpeeds returned error: %v", batteryWh, err)
+		}
+		if got.Race.DistanceM <= got.ConstantDistanceM {
+			t.Fatalf("battery %.0f Wh: got %.1f m, want more than constant-speed %.1f m", batteryWh, got.Race.DistanceM, got.ConstantDistanceM)
+		}
+		if got.Race.RemainingEnergyWh < 0 {
+			t.Fatalf("battery %.0f Wh: got remaining energy %.6f Wh, want non-negative", batteryWh, got.Race.RemainingEnergyWh)
+		}
+
+		// the reported race must be what the plan actually drives
+		plan := make([]float64, len(got.Plan))
+		for i, target := range got.Plan {
+			plan[i] = target.TargetSpeed
+		}
+		race, _, err := evaluateSpeedPlan(defaultTrackSegments(), inputs, plan)
+		if err != nil {
+			t.Fatalf("evaluateSpeedPlan returned error: %v", err)
+		}
+		if math.Abs(race.DistanceM-got.Race.DistanceM) > 1e-6 {
+			t.Fatalf("battery %.0f Wh: got replayed distance %.3f m, want %.3f", batteryWh, race.DistanceM, got.Race.DistanceM)
+		}
+
+		// and a reported λ must be the one that produced it
+		if got.TimeWeightWhPerS != 0 {
+			lengths, caps := segmentSpeedCaps(defaultTrackSegments(), inputs)
+			want := closedLapPlan(lengths, caps, inputs, got.TimeWeightWhPerS, got.ConstantV)
+			for i := range plan {
+				if plan[i] != want[i] {
+					t.Fatalf("battery %.0f Wh: segment %d plans %.2f m/s, want %.2f for λ %.4f", batteryWh, i, plan[i], want[i], got.TimeWeightWhPerS)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const defaultSensitivityRelStep = 0.01
+
+// sensitivityEntry is how race distance responds to one input around the
+// baseline, with the optimal speed re-solved at each perturbed point.
+type sensitivityEntry struct {
+	Field      string  `json:"field"`
+	Value      float64 `json:"value"`
+	Step       float64 `json:"step"` // absolute perturbation used
+	DistanceM  float64 `json:"distanceM"`
+	Derivative float64 `json:"derivative"` // m of race distance per unit of the field
+	Elasticity float64 `json:"elasticity"` // % distance change per % field change; 0 for a zero baseline
+	ImpactM    float64 `json:"impactM"`    // distance change for a +Step change, used for ranking
+	OptimalV   float64 `json:"optimalV"`   // at +Step
+	Note       string  `json:"note,omitempty"`
+}
+
+// sensitivityResult ranks inputs by |ImpactM|, biggest first.
+type sensitivityResult struct {
+	BaselineDistanceM float64            `json:"baselineDistanceM"`
+	BaselineV         float64            `json:"baselineV"`
+	RelStep           float64            `json:"relStep"`
+	Parameters        []sensitivityEntry `json:"parameters"`
+}
+
+// optimalDistance re-solves the cruise speed for inputs and returns the
+// distance there; false when the inputs are invalid or infeasible.
+func optimalDistance(inputs simulationInputs) (optimizeResult, bool) {
+	if validateSimulationInputs(inputs) != nil {
+		return optimizeResult{}, false
+	}
+	search, err := optimalSpeedSearch(inputs, defaultSpeedSearchOptions())
+	return search, err == nil
+}
+
+// analyzeSensitivity perturbs each field by ±relStep of its baseline value
+// (or ±relStep absolute when the baseline is zero) and takes a central
+// difference. Where one side is outside the valid inputs it falls back to a
+// one-sided difference. An empty fields list means every numeric input.
+func analyzeSensitivity(base simulationInputs, fields []string, relStep float64) (sensitivityResult, error) {
+	if relStep <= 0 || relStep >= 1 {
+		return sensitivityResult{}, fmt.Errorf("relStep must be between 0 and 1")
+	}
+	if len(fields) == 0 {
+		fields = inputFieldNames()
+	}
+	baseline, ok := optimalDistance(base)
+	if !ok {
+		return sensitivityResult{}, fmt.Errorf("baseline inputs are not feasible for the model")
+	}
+	result := sensitivityResult{BaselineDistanceM: baseline.Value, BaselineV: baseline.X, RelStep: relStep}
+
+	for _, field := range fields {
+		value, err := inputField(base, field)
+		if err != nil {
+			return sensitivityResult{}, err
+		}
+		step := math.Abs(value) * relStep
+		if step == 0 {
+			step = relStep
+		}
+		entry := sensitivityEntry{Field: field, Value: value, Step: step, DistanceM: baseline.Value}
+
+		at := func(x float64) (optimizeResult, bool) {
+			inputs := base
+			_ = setInputField(&inputs, field, x)
+			return optimalDistance(inputs)
+		}
+		plus, okPlus := at(value + step)
+		minus, okMinus := at(value - step)
+		switch {
+		case okPlus && okMinus:
+			entry.Derivative = (plus.Value - minus.Value) / (2 * step)
+		case okPlus:
+			entry.Derivative = (plus.Value - baseline.Value) / step
+			entry.Note = "forward difference"
+		case okMinus:
+			entry.Derivative = (baseline.Value - minus.Value) / step
+			entry.Note = "backward difference"
+		default:
+			entry.Note = "both perturbations are outside the valid inputs"
+		}
+		if okPlus {
+			entry.OptimalV = plus.X
+		}
+		entry.ImpactM = entry.Derivative * step
+		if value != 0 {
+			entry.Elasticity = entry.Derivative * value / baseline.Value
+		}
+		result.Parameters = append(result.Parameters, entry)
+	}
+
+	sort.SliceStable(result.Parameters, func(i, j int) bool {
+		return math.Abs(result.Parameters[i].ImpactM) > math.Abs(result.Parameters[j].ImpactM)
+	})
+	return result, nil
+}
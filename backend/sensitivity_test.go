@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnalyzeSensitivityMatchesFiniteDifference(t *testing.T) {
+	base := defaultSimulationInputs()
+	got, err := analyzeSensitivity(base, []string{"cD"}, 0.01)
+	if err != nil {
+		t.Fatalf("analyzeSensitivity returned error: %v", err)
+	}
+	if len(got.Parameters) != 1 {
+		t.Fatalf("got %d parameters, want 1", len(got.Parameters))
+	}
+	cd := got.Parameters[0]
+
+	plus, minus := base, base
+	plus.Cd *= 1.01
+	minus.Cd *= 0.99
+	dPlus, _ := optimalDistance(plus)
+	dMinus, _ := optimalDistance(minus)
+	want := (dPlus.Value - dMinus.Value) / (0.02 * base.Cd)
+	if math.Abs(cd.Derivative-want) > 1e-6*math.Abs(want) {
+		t.Fatalf("got derivative %.6f m per unit, want %.6f", cd.Derivative, want)
+	}
+	if cd.Derivative >= 0 || cd.Elasticity >= 0 {
+		t.Fatalf("got derivative %.3f and elasticity %.3f, want both negative for drag", cd.Derivative, cd.Elasticity)
+	}
+	if math.Abs(cd.Elasticity-want*base.Cd/got.BaselineDistanceM) > 1e-9 {
+		t.Fatalf("got elasticity %.6f, want %.6f", cd.Elasticity, want*base.Cd/got.BaselineDistanceM)
+	}
+}
+
+func TestAnalyzeSensitivityRanksByImpact(t *testing.T) {
+	got, err := analyzeSensitivity(defaultSimulationInputs(), nil, 0.01)
+	if err != nil {
+		t.Fatalf("analyzeSensitivity returned error: %v", err)
+	}
+	if len(got.Parameters) != len(inputFieldNames()) {
+		t.Fatalf("got %d parameters, want every input (%d)", len(got.Parameters), len(inputFieldNames()))
+	}
+	for i := 1; i < len(got.Parameters); i++ {
+		if math.Abs(got.Parameters[i].ImpactM) > math.Abs(got.Parameters[i-1].ImpactM) {
+			t.Fatalf("parameter %d (%s) has more impact than %d (%s)", i, got.Parameters[i].Field, i-1, got.Parameters[i-1].Field)
+		}
+	}
+}
+
+func TestSensitivityHandlerRejectsUnknownField(t *testing.T) {
+	body, err := json.Marshal(sensitivityRequest{Inputs: defaultSimulationInputs(), Fields: []string{"wings"}})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/sensitivity", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	sensitivityHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
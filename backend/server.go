@@ -9,6 +9,7 @@ import (
 	//used for decoding JSON into distanceRequest struct
 	//also for encoding struct back into JSON format for HTTP response
 	"encoding/json"
+	"errors"
 	"strconv"
 
 	//allows for original sim to be called via terminal using flag
@@ -17,16 +18,19 @@ import (
 	"log"
 	"math"
 	"net/http" //lets go program talk over web --> Receive requests and send responses
+	"strings"
+	"time"
 )
 
 type distanceRequest = simulationInputs
 
 type distanceResponse struct {
-	DistanceM         float64 `json:"distanceM"`
-	OptimalV          float64 `json:"optimalV"`
-	RemainingEnergyWh float64 `json:"remainingEnergyWh"`
-	OK                bool    `json:"ok"`
-	Message           string  `json:"message,omitempty"`
+	DistanceM         float64        `json:"distanceM"`
+	OptimalV          float64        `json:"optimalV"`
+	RemainingEnergyWh float64        `json:"remainingEnergyWh"`
+	SpeedSearch       optimizeResult `json:"speedSearch"`
+	OK                bool           `json:"ok"`
+	Message           string         `json:"message,omitempty"`
 }
 
 type simulateRequest struct {
@@ -35,14 +39,251 @@ type simulateRequest struct {
 }
 
 type simulateResponse struct {
-	DistanceM         float64          `json:"distanceM"`
+	DistanceM         float64          `json:"distanceM"`       // from repeating the simulated lap
+	CruiseDistanceM   float64          `json:"cruiseDistanceM"` // constant-speed DistanceForSpeedEV estimate
 	OptimalV          float64          `json:"optimalV"`
 	RemainingEnergyWh float64          `json:"remainingEnergyWh"`
+	LapsCompleted     int              `json:"lapsCompleted"`
+	LapTimeS          float64          `json:"lapTimeS"`
+	LapEnergyWh       float64          `json:"lapEnergyWh"`
+	LimitedBy         string           `json:"limitedBy,omitempty"`
+	LapEnergy         energyBreakdown  `json:"lapEnergy"`
+	SegmentEnergy     []segmentEnergy  `json:"segmentEnergy"`
+	SpeedSearch       optimizeResult   `json:"speedSearch"`
 	Points            []telemetryPoint `json:"points"`
 	OK                bool             `json:"ok"`
 	Message           string           `json:"message,omitempty"`
 }
 
+type multiDayRequest struct {
+	Inputs         simulationInputs `json:"inputs"`
+	Site           solarSite        `json:"site"`
+	StartDate      string           `json:"startDate"` // YYYY-MM-DD in site timezone; empty means today, past dates replay the archive
+	Days           int              `json:"days"`
+	StartBatteryWh *float64         `json:"startBatteryWh,omitempty"` // empty means a full pack
+}
+
+type multiDayResponse struct {
+	Days           []multiDayResult `json:"days"`
+	TotalDistanceM float64          `json:"totalDistanceM"`
+	TotalLaps      float64          `json:"totalLaps"`
+	OK             bool             `json:"ok"`
+	Message        string           `json:"message,omitempty"`
+}
+
+type raceLapsRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+	// Site gives solar from the hourly forecast (or archive) for the race
+	// window. It defaults to defaultSolarSite as in multiDayRequest; null
+	// keeps the constant inputs.SolarWhPerMin.
+	Site      *solarSite `json:"site"`
+	StartTime string     `json:"startTime,omitempty"` // YYYY-MM-DDTHH:MM in site timezone; empty means today 09:00
+}
+
+type raceLapsResponse struct {
+	raceLapsResult
+	OptimalV float64 `json:"optimalV"`
+	OK       bool    `json:"ok"`
+	Message  string  `json:"message,omitempty"`
+}
+
+type segmentStrategyRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+}
+
+type segmentStrategyResponse struct {
+	segmentStrategyResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type speedScheduleRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+	// Site switches solar from the constant inputs.SolarWhPerMin to the
+	// hourly forecast (or archive) for the race window.
+	Site           *solarSite `json:"site,omitempty"`
+	StartTime      string     `json:"startTime,omitempty"` // YYYY-MM-DDTHH:MM in site timezone; empty means today 09:00
+	BlockMin       float64    `json:"blockMin,omitempty"`  // empty means 15 minutes
+	BatteryFloorWh float64    `json:"batteryFloorWh"`
+}
+
+type speedScheduleResponse struct {
+	speedScheduleResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type sweepRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+	MinV   float64          `json:"minV"`
+	MaxV   float64          `json:"maxV"`
+	StepV  float64          `json:"stepV"`
+	Laps   bool             `json:"laps"` // also run the lap simulation at each speed
+}
+
+type sweepResponse struct {
+	Points   []sweepPoint `json:"points"`
+	OptimalV float64      `json:"optimalV"`
+	OK       bool         `json:"ok"`
+	Message  string       `json:"message,omitempty"`
+}
+
+type tradeStudyRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+	X      tradeAxis        `json:"x"`
+	Y      tradeAxis        `json:"y"`
+}
+
+type tradeStudyResponse struct {
+	tradeStudyResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type sensitivityRequest struct {
+	Inputs  simulationInputs `json:"inputs"`
+	Fields  []string         `json:"fields,omitempty"`  // empty means every numeric input
+	RelStep float64          `json:"relStep,omitempty"` // empty means 1%
+}
+
+type sensitivityResponse struct {
+	sensitivityResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type monteCarloRequest struct {
+	Inputs        simulationInputs             `json:"inputs"`
+	Distributions map[string]inputDistribution `json:"distributions"` // keyed by inputs field name
+	Samples       int                          `json:"samples"`
+	Seed          uint64                       `json:"seed"`
+	Percentiles   []float64                    `json:"percentiles,omitempty"` // empty means P10/P50/P90
+}
+
+type monteCarloResponse struct {
+	monteCarloResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type goalSeekRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+	Field  string           `json:"field"`  // free inputs field, e.g. "batteryWh"
+	Metric string           `json:"metric"` // "distance" (m), "laps" or "remainingEnergy" (Wh)
+	Target float64          `json:"target"`
+	Min    float64          `json:"min,omitempty"` // search range; empty means the field's default range
+	Max    float64          `json:"max,omitempty"`
+	V      float64          `json:"v,omitempty"` // hold this cruise speed instead of re-optimising
+}
+
+type goalSeekResponse struct {
+	goalSeekResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type coastdownRequest struct {
+	Inputs           simulationInputs `json:"inputs"` // M, G, Rho, A and AdditionalEfficiency are used in the fit
+	Runs             []coastdownRun   `json:"runs"`
+	MinSpeed         float64          `json:"minSpeed,omitempty"`         // m/s; empty means 2
+	RotationalMassKg float64          `json:"rotationalMassKg,omitempty"` // equivalent mass of spinning parts
+}
+
+type coastdownResponse struct {
+	coastdownResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type telemetryCalibrationRequest struct {
+	Inputs   simulationInputs `json:"inputs"`
+	Segments []trackSegment   `json:"segments,omitempty"` // empty means the default track
+	Samples  []raceLogSample  `json:"samples"`            // one recorded lap
+	V        float64          `json:"v,omitempty"`        // model cruise target; empty means the 90th percentile logged speed
+	Fit      []string         `json:"fit,omitempty"`      // any of etaDrive, additionalEfficiency, cRr
+}
+
+type telemetryCalibrationResponse struct {
+	telemetryCalibrationResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type liveSessionRequest struct {
+	Inputs simulationInputs `json:"inputs"` // plan inputs, as sent to /simulate
+}
+
+type liveSamplesRequest struct {
+	Samples []liveSample `json:"samples"`
+}
+
+type liveSessionResponse struct {
+	ID      string       `json:"id,omitempty"`
+	Plan    *livePlan    `json:"plan,omitempty"`
+	Delta   liveDelta    `json:"delta"`
+	Dash    *dashMessage `json:"dash,omitempty"` // as published, when the session has a dash
+	OK      bool         `json:"ok"`
+	Message string       `json:"message,omitempty"`
+}
+
+type dashResponse struct {
+	ID      string      `json:"id,omitempty"`
+	Target  *dashTarget `json:"target,omitempty"`
+	Plan    *dashPlan   `json:"plan,omitempty"`
+	OK      bool        `json:"ok"`
+	Message string      `json:"message,omitempty"`
+}
+
+type replanRequest struct {
+	Inputs simulationInputs `json:"inputs"`
+	// Site switches solar from the constant inputs.SolarWhPerMin to the
+	// hourly forecast for the rest of the day.
+	Site         *solarSite `json:"site,omitempty"`
+	CurrentTime  string     `json:"currentTime,omitempty"`  // YYYY-MM-DDTHH:MM in site timezone (UTC without a site); empty means now
+	RaceEnd      string     `json:"raceEnd,omitempty"`      // YYYY-MM-DDTHH:MM; used when remainingMin is empty
+	RemainingMin float64    `json:"remainingMin,omitempty"` // minutes of race window left
+	SOC          *float64   `json:"soc,omitempty"`          // % of inputs.batteryWh
+	BatteryWh    *float64   `json:"currentBatteryWh,omitempty"`
+	LapsDone     float64    `json:"lapsDone"`
+}
+
+type replanResponse struct {
+	replanResult
+	CurrentTime string `json:"currentTime"`
+	OK          bool   `json:"ok"`
+	Message     string `json:"message,omitempty"`
+}
+
+type lapDetectionRequest struct {
+	Samples      []raceLogSample `json:"samples"` // GPS log; lat and lon are required
+	Gate         trackGate       `json:"gate"`    // start/finish line
+	Sectors      []trackGate     `json:"sectors,omitempty"`
+	MinLapS      float64         `json:"minLapS,omitempty"`      // empty means 30 s
+	TrackLengthM float64         `json:"trackLengthM,omitempty"` // empty means the default track's lap length
+}
+
+type lapDetectionResponse struct {
+	lapDetectionResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type canDecodeRequest struct {
+	DBC       string           `json:"dbc"`              // DBC file contents
+	Log       string           `json:"log"`              // candump text or CSV (time_s,id,data)
+	Format    string           `json:"format,omitempty"` // "candump" or "csv"; empty detects it
+	Channels  canChannelMap    `json:"channels"`
+	PeriodS   float64          `json:"periodS,omitempty"`   // timeline step, at least 0.001 s; empty means 0.1 s
+	GearRatio float64          `json:"gearRatio,omitempty"` // motor revs per wheel rev; empty means 1 (hub motor)
+	Inputs    simulationInputs `json:"inputs"`              // rWheel converts motor RPM to speed
+}
+
+type canDecodeResponse struct {
+	canDecodeResult
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
 type trackSegment struct {
 	Type      string  `json:"type"`
 	Length    float64 `json:"length,omitempty"`
@@ -51,96 +292,962 @@ type trackSegment struct {
 	Direction string  `json:"direction,omitempty"`
 }
 
-type trackResponse struct {
-	Segments []trackSegment `json:"segments"`
+type trackResponse struct {
+	Segments []trackSegment `json:"segments"`
+}
+
+type telemetryPoint struct {
+	X             float64 `json:"x"`
+	Y             float64 `json:"y"`
+	Speed         float64 `json:"speed"`
+	Accel         float64 `json:"accel"`
+	Distance      float64 `json:"distance"`
+	CurveSpeedCap float64 `json:"curveSpeedCap"` // 0 on straights; sqrt(gmax*g*r) on curves
+	ElapsedS      float64 `json:"elapsedS"`      // lap clock at this point
+	WheelPowerW   float64 `json:"wheelPowerW"`   // traction power over the step into this point; negative when coasting/braking
+	BatteryPowerW float64 `json:"batteryPowerW"` // drawn from the battery over that step (no regen)
+	EnergyUsedWh  float64 `json:"energyUsedWh"`  // cumulative battery energy since the start of the lap
+	LateralAccel  float64 `json:"lateralAccel"`  // v²/r, positive in left-hand curves
+	Heading       float64 `json:"heading"`       // radians, 0 along +x at the start line
+	Segment       int     `json:"segment"`       // index into the track segments
+}
+
+type telemetryResponse struct {
+	Points []telemetryPoint `json:"points"`
+}
+
+var optimalCruiseSpeed float64
+
+// relocated main bc this is new entry point
+// sim now becomes function
+func main() {
+	mode := flag.String("mode", "server", "mode: server, simulate, multiday, coastdown, calibrate or can") //checking for user flags for sim for server
+	addr := flag.String("addr", ":8080", "server listen address")                                          //checking flag to choose different network port in cases 8080 is in use
+	days := flag.Int("days", 4, "race days for multiday mode")
+	date := flag.String("date", "", "first race day (YYYY-MM-DD) for multiday mode; past dates replay archived weather")
+	coastdownFile := flag.String("coastdown", "", "coastdown log CSV (run,direction,time_s,speed_mps) for coastdown mode")
+	logFile := flag.String("log", "", "recorded lap CSV (time_s,speed_mps,current_a,voltage_v[,lat,lon,distance_m,array_power_w]) for calibrate mode")
+	fitFields := flag.String("fit", "", "comma-separated inputs to fit in calibrate mode: etaDrive, additionalEfficiency, cRr")
+	dbcFile := flag.String("dbc", "", "DBC message definitions for can mode")
+	canLog := flag.String("can-log", "", "candump or CSV (time_s,id,data) CAN log for can mode; the timeline CSV goes to stdout")
+	presetOut := flag.String("preset-out", "", "write the calibrated preset JSON here in calibrate mode")
+	flag.StringVar(&weatherArchiveURL, "archive-url", weatherArchiveURL, "hourly weather archive endpoint")
+	flag.StringVar(&dashAllowedHosts, "dash-hosts", dashAllowedHosts, "comma-separated dash/MQTT hosts allowed besides loopback and private addresses")
+	flag.Parse() //fills pointers (mode and addr) with values based on terminal inputs
+
+	//if flag is simulate run sim
+	if *mode == "simulate" {
+		runSimulation()
+		return
+	}
+	if *mode == "multiday" {
+		runMultiDaySimulation(*days, *date)
+		return
+	}
+	if *mode == "coastdown" {
+		runCoastdownCalibration(*coastdownFile)
+		return
+	}
+	if *mode == "can" {
+		runCANDecode(*dbcFile, *canLog)
+		return
+	}
+	if *mode == "calibrate" {
+		runTelemetryCalibration(*logFile, *fitFields, *presetOut)
+		return
+	}
+	//find cruise speed
+	optimalCruiseSpeed = computeOptimalSpeed()
+	//empty router (router is meant to map url to handler)
+	mux := http.NewServeMux() //request router (empty --> no route to go), serve multiplexer -->takes http requests and routes it
+	mux.HandleFunc("/defaults", defaultsHandler)
+	mux.HandleFunc("/distance", distanceHandler) // handler that router directs oncoming requests
+	mux.HandleFunc("/simulate", simulateHandler)
+	mux.HandleFunc("/sweep", sweepHandler)
+	mux.HandleFunc("/tradestudy", tradeStudyHandler)
+	mux.HandleFunc("/sensitivity", sensitivityHandler)
+	mux.HandleFunc("/montecarlo", monteCarloHandler)
+	mux.HandleFunc("/goalseek", goalSeekHandler)
+	mux.HandleFunc("/can/decode", canDecodeHandler)
+	mux.HandleFunc("/calibrate/coastdown", coastdownHandler)
+	mux.HandleFunc("/calibrate/telemetry", telemetryCalibrationHandler)
+	mux.HandleFunc("/laps/detect", lapDetectionHandler)
+	mux.HandleFunc("/live/sessions", liveSessionsHandler)
+	mux.HandleFunc("/live/sessions/{id}", liveSessionHandler)
+	mux.HandleFunc("/live/sessions/{id}/samples", liveSamplesHandler)
+	mux.HandleFunc("/live/sessions/{id}/dash", liveDashHandler)
+	mux.HandleFunc("/multiday", multiDayHandler)
+	mux.HandleFunc("/replan", replanHandler)
+	mux.HandleFunc("/race/laps", raceLapsHandler)
+	mux.HandleFunc("/strategy/segments", segmentStrategyHandler)
+	mux.HandleFunc("/strategy/schedule", speedScheduleHandler)
+	mux.HandleFunc("/track", trackHandler)
+	mux.HandleFunc("/track/telemetry", trackTelemetryHandler)
+
+	log.Printf("listening on %s", *addr) //%s is replaced with dereferenced addr
+
+	//handles errors
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// is the HTTP handler
+// w --> is outgoing http response (write)
+// r --> incoming http request. pointer to struct with everything client sent (read)
+func distanceHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	//check to see if OPTIONS request then do nothing (this happens before API request)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	//handler is called again (two http requests are made)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := defaultSimulationInputs() // prefill with backend defaults, then let JSON override provided fields
+	dec := json.NewDecoder(r.Body)   //decode JSON and read
+	dec.DisallowUnknownFields()      //decoding will fail if JSON has fields that are not valid
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: err.Error()})
+		return
+	}
+	//compute optimal cruise speed for these inputs
+	search, err := optimalSpeedSearch(req, defaultSpeedSearchOptions())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: "inputs are not feasible for the model"})
+		return
+	}
+	req.V = search.X
+	//run sim if everything is valid
+	distance, ok := distanceForInputs(req)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: "inputs are not feasible for the model"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, distanceResponse{DistanceM: distance, OptimalV: req.V, RemainingEnergyWh: remainingEnergyForInputs(req), SpeedSearch: search, OK: true})
+}
+
+func simulateHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := simulateRequest{
+		Inputs:     defaultSimulationInputs(),
+		Wraparound: true,
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	//compute optimal cruise speed for these inputs
+	search, err := optimalSpeedSearch(req.Inputs, defaultSpeedSearchOptions())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: "inputs are not feasible for the model"})
+		return
+	}
+	req.Inputs.V = search.X
+
+	distance, ok := distanceForInputs(req.Inputs)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: "inputs are not feasible for the model"})
+		return
+	}
+
+	segments := defaultTrackSegments()
+	points, err := buildTelemetryForInputs(segments, req.Wraparound, req.Inputs)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, simulateResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	// race distance comes from the simulated lap, not the flat-straight cruise
+	race, err := trackRaceDistance(points, req.Inputs)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, simulateResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	lapEnergy, segmentEnergy := lapEnergyBreakdown(points, segments, req.Inputs)
+
+	writeJSON(w, http.StatusOK, simulateResponse{
+		DistanceM:         race.DistanceM,
+		CruiseDistanceM:   distance,
+		OptimalV:          req.Inputs.V,
+		RemainingEnergyWh: race.RemainingEnergyWh,
+		LapsCompleted:     race.LapsCompleted,
+		LapTimeS:          race.LapTimeS,
+		LapEnergyWh:       race.LapEnergyWh,
+		LimitedBy:         race.LimitedBy,
+		LapEnergy:         lapEnergy,
+		SegmentEnergy:     segmentEnergy,
+		SpeedSearch:       search,
+		Points:            points,
+		OK:                true,
+	})
+}
+
+// sweepHandler evaluates the cruise model (and optionally the lap
+// simulation) across a speed range so the trade-off curve can be plotted.
+func sweepHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := sweepRequest{
+		Inputs: defaultSimulationInputs(),
+		MinV:   defaultSweepMinV,
+		MaxV:   defaultSweepMaxV,
+		StepV:  defaultSweepStepV,
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, sweepResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, sweepResponse{OK: false, Message: err.Error()})
+		return
+	}
+	if _, err := sweepSpeedCount(req.MinV, req.MaxV, req.StepV); err != nil {
+		writeJSON(w, http.StatusBadRequest, sweepResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	points, err := sweepSpeeds(req.Inputs, req.MinV, req.MaxV, req.StepV, req.Laps)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, sweepResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, sweepResponse{Points: points, OptimalV: computeOptimalSpeedForInputs(req.Inputs), OK: true})
+}
+
+// tradeStudyHandler varies two inputs over a grid and returns the race
+// distance at the optimal speed for each pair, as JSON or, with
+// ?format=csv, as one CSV row per cell.
+func tradeStudyHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, tradeStudyResponse{OK: false, Message: fmt.Sprintf("invalid format query value %q", format)})
+		return
+	}
+
+	req := tradeStudyRequest{Inputs: defaultSimulationInputs()}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, tradeStudyResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, tradeStudyResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := runTradeStudy(req.Inputs, req.X, req.Y)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, tradeStudyResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="tradestudy.csv"`)
+		w.WriteHeader(http.StatusOK)
+		if err := writeTradeStudyCSV(w, result); err != nil {
+			log.Printf("tradestudy: writing CSV: %v", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, tradeStudyResponse{tradeStudyResult: result, OK: true})
+}
+
+// sensitivityHandler ranks the inputs by how much race distance they move
+// around the requested baseline.
+func sensitivityHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := sensitivityRequest{Inputs: defaultSimulationInputs(), RelStep: defaultSensitivityRelStep}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, sensitivityResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, sensitivityResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := analyzeSensitivity(req.Inputs, req.Fields, req.RelStep)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, sensitivityResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, sensitivityResponse{sensitivityResult: result, OK: true})
+}
+
+// monteCarloHandler samples the uncertain inputs and returns percentiles of
+// race distance, optimal speed and remaining energy.
+func monteCarloHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := monteCarloRequest{Inputs: defaultSimulationInputs(), Samples: defaultMonteCarloSamples}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, monteCarloResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, monteCarloResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := runMonteCarlo(req.Inputs, req.Distributions, req.Samples, req.Seed, req.Percentiles)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, monteCarloResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, monteCarloResponse{monteCarloResult: result, OK: true})
+}
+
+// goalSeekHandler solves for the value of one input that reaches a target
+// distance, lap count or remaining energy.
+func goalSeekHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := goalSeekRequest{Inputs: defaultSimulationInputs(), Metric: "distance"}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, goalSeekResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, goalSeekResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := goalSeek(req.Inputs, req.Field, req.Metric, req.Target, req.Min, req.Max, req.V)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, goalSeekResponse{OK: false, Message: err.Error()})
+		return
+	}
+	resp := goalSeekResponse{goalSeekResult: result, OK: true}
+	if !result.Reachable {
+		resp.Message = fmt.Sprintf("target is unreachable for %s in [%g, %g]; closest is %g at %g", result.Field, result.RangeMin, result.RangeMax, result.Achieved, result.Value)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// canDecodeHandler decodes a CAN log with a DBC onto a common timeline, as
+// JSON or, with ?format=csv, as a CSV the telemetry tools read back.
+func canDecodeHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, canDecodeResponse{OK: false, Message: fmt.Sprintf("invalid format query value %q", format)})
+		return
+	}
+
+	req := canDecodeRequest{Inputs: defaultSimulationInputs()}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, canDecodeResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, canDecodeResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	db, err := parseDBC(strings.NewReader(req.DBC))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, canDecodeResponse{OK: false, Message: err.Error()})
+		return
+	}
+	frames, err := decodeCANLog(req.Log, req.Format)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, canDecodeResponse{OK: false, Message: err.Error()})
+		return
+	}
+	result, err := decodeCANTimeline(db, frames, req.Channels, req.PeriodS, req.Inputs.RWheel, req.GearRatio)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, canDecodeResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="can_timeline.csv"`)
+		w.WriteHeader(http.StatusOK)
+		if err := writeCANTimelineCSV(w, result); err != nil {
+			log.Printf("can/decode: writing CSV: %v", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, canDecodeResponse{canDecodeResult: result, OK: true})
+}
+
+// coastdownHandler fits Crr and CdA to logged coastdown runs and returns the
+// preset update with 95% confidence intervals.
+func coastdownHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := coastdownRequest{Inputs: defaultSimulationInputs()}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, coastdownResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, coastdownResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := fitCoastdown(req.Inputs, req.Runs, req.MinSpeed, req.RotationalMassKg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, coastdownResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, coastdownResponse{coastdownResult: result, OK: true})
+}
+
+// telemetryCalibrationHandler compares a recorded lap with the model lap and
+// optionally fits drivetrain and rolling losses to it.
+func telemetryCalibrationHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := telemetryCalibrationRequest{Inputs: defaultSimulationInputs()}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, telemetryCalibrationResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, telemetryCalibrationResponse{OK: false, Message: err.Error()})
+		return
+	}
+	if len(req.Segments) == 0 {
+		req.Segments = defaultTrackSegments()
+	}
+
+	result, err := calibrateTelemetry(req.Inputs, req.Segments, req.Samples, req.V, req.Fit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, telemetryCalibrationResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, telemetryCalibrationResponse{telemetryCalibrationResult: result, OK: true})
+}
+
+// liveSessionsHandler starts a race-day session compared against the
+// /simulate plan for the posted inputs.
+func liveSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := liveSessionRequest{Inputs: defaultSimulationInputs()}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, liveSessionResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, liveSessionResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	plan, err := buildLivePlan(req.Inputs)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, liveSessionResponse{OK: false, Message: err.Error()})
+		return
+	}
+	session, err := liveSessions.create(plan)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, liveSessionResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, liveSessionResponse{ID: session.ID, Plan: &session.Plan, Delta: session.delta(), OK: true})
+}
+
+// liveSessionHandler returns a session's plan and current delta (GET) or
+// ends the session (DELETE).
+func liveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		session, ok := liveSessions.get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, liveSessionResponse{OK: false, Message: "unknown session"})
+			return
+		}
+		writeJSON(w, http.StatusOK, liveSessionResponse{ID: session.ID, Plan: &session.Plan, Delta: session.delta(), OK: true})
+	case http.MethodDelete:
+		if !liveSessions.remove(id) {
+			writeJSON(w, http.StatusNotFound, liveSessionResponse{OK: false, Message: "unknown session"})
+			return
+		}
+		writeJSON(w, http.StatusOK, liveSessionResponse{ID: id, OK: true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// liveSamplesHandler appends streamed samples to a session and returns the
+// delta against the plan at the latest one.
+func liveSamplesHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := liveSessions.get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, liveSessionResponse{OK: false, Message: "unknown session"})
+		return
+	}
+	var req liveSamplesRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, liveSessionResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+	if err := session.addSamples(req.Samples); err != nil {
+		writeJSON(w, http.StatusBadRequest, liveSessionResponse{ID: session.ID, Delta: session.delta(), OK: false, Message: err.Error()})
+		return
+	}
+	// a dash that cannot be reached must not stop the samples being stored
+	dash, err := session.broadcast()
+	if err != nil {
+		log.Printf("dash broadcast for session %s: %v", session.ID, err)
+	}
+	writeJSON(w, http.StatusOK, liveSessionResponse{ID: session.ID, Delta: session.delta(), Dash: dash, OK: true})
+}
+
+// liveDashHandler starts publishing a session's position against the plan's
+// target speeds and coast/brake points to a dash over UDP or MQTT (POST), or
+// stops it (DELETE). A message goes out each time samples are posted.
+func liveDashHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := liveSessions.get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, dashResponse{OK: false, Message: "unknown session"})
+		return
+	}
+	if r.Method == http.MethodDelete {
+		session.setDash(nil)
+		writeJSON(w, http.StatusOK, dashResponse{ID: session.ID, OK: true})
+		return
+	}
+
+	var target dashTarget
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&target); err != nil {
+		writeJSON(w, http.StatusBadRequest, dashResponse{ID: session.ID, OK: false, Message: "invalid JSON body"})
+		return
+	}
+	if err := target.validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, dashResponse{ID: session.ID, OK: false, Message: err.Error()})
+		return
+	}
+	inputs := session.Plan.Inputs
+	inputs.V = session.Plan.OptimalV
+	plan, err := buildDashPlan(defaultTrackSegments(), inputs)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, dashResponse{ID: session.ID, OK: false, Message: err.Error()})
+		return
+	}
+	pub, err := dialDash(target)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, dashResponse{ID: session.ID, OK: false, Message: err.Error()})
+		return
+	}
+	session.setDash(&dashBroadcast{Plan: plan, Target: target, pub: pub})
+	writeJSON(w, http.StatusOK, dashResponse{ID: session.ID, Target: &target, Plan: &plan, OK: true})
 }
 
-type telemetryPoint struct {
-	X             float64 `json:"x"`
-	Y             float64 `json:"y"`
-	Speed         float64 `json:"speed"`
-	Accel         float64 `json:"accel"`
-	Distance      float64 `json:"distance"`
-	CurveSpeedCap float64 `json:"curveSpeedCap"` // 0 on straights; sqrt(gmax*g*r) on curves
+// replanHandler re-solves the strategy for the rest of the race from the
+// car's current time, battery and laps.
+func replanHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := replanRequest{Inputs: defaultSimulationInputs()}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	var batteryWh float64
+	switch {
+	case req.BatteryWh != nil:
+		batteryWh = *req.BatteryWh
+	case req.SOC != nil:
+		batteryWh = *req.SOC / 100 * req.Inputs.BatteryWh
+	default:
+		writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: "need soc or currentBatteryWh"})
+		return
+	}
+
+	loc := time.UTC
+	if req.Site != nil {
+		var err error
+		if loc, err = time.LoadLocation(req.Site.Timezone); err != nil {
+			writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: "invalid site timezone"})
+			return
+		}
+	}
+	now := time.Now().In(loc).Truncate(time.Minute)
+	if req.CurrentTime != "" {
+		var err error
+		if now, err = time.ParseInLocation("2006-01-02T15:04", req.CurrentTime, loc); err != nil {
+			writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: "currentTime must be YYYY-MM-DDTHH:MM"})
+			return
+		}
+	}
+	if req.RemainingMin <= 0 && req.RaceEnd != "" {
+		end, err := time.ParseInLocation("2006-01-02T15:04", req.RaceEnd, loc)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: "raceEnd must be YYYY-MM-DDTHH:MM"})
+			return
+		}
+		req.RemainingMin = end.Sub(now).Minutes()
+	}
+	if req.RemainingMin <= 0 {
+		writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: "need remainingMin or a raceEnd after currentTime"})
+		return
+	}
+	if req.RemainingMin > maxRaceWindowMin {
+		writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: fmt.Sprintf("remaining race window must be at most %d minutes", maxRaceWindowMin)})
+		return
+	}
+
+	currentTime := now.Format("2006-01-02T15:04")
+	solar, err := raceSolarForRequest(req.Site, currentTime, req.RemainingMin)
+	if err != nil {
+		writeJSON(w, weatherErrorStatus(err), replanResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := replanRace(req.Inputs, solar, req.RemainingMin, batteryWh, req.LapsDone)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, replanResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, replanResponse{replanResult: result, CurrentTime: currentTime, OK: true})
 }
 
-type telemetryResponse struct {
-	Points []telemetryPoint `json:"points"`
+// lapDetectionHandler cuts a GPS log into laps and sectors and maps every
+// fix onto the model track's distance axis.
+func lapDetectionHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req lapDetectionRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, lapDetectionResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+	if req.TrackLengthM == 0 {
+		req.TrackLengthM = getTotalLength(telemetryTrackFromSegments(defaultTrackSegments()))
+	}
+
+	result, err := detectLaps(req.Samples, req.Gate, req.Sectors, req.MinLapS, req.TrackLengthM)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, lapDetectionResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, lapDetectionResponse{lapDetectionResult: result, OK: true})
 }
 
-var optimalCruiseSpeed float64
+// multiDayHandler runs consecutive race days with overnight SOC carry-over,
+// using the forecast solar for each charge and race window. Each race runs
+// inputs.raceDayMin from 09:00.
+func multiDayHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-// relocated main bc this is new entry point
-// sim now becomes function
-func main() {
-	mode := flag.String("mode", "server", "mode: server or simulate") //checking for user flags for sim for server
-	addr := flag.String("addr", ":8080", "server listen address")     //checking flag to choose different network port in cases 8080 is in use
-	flag.Parse()                                                      //fills pointers (mode and addr) with values based on terminal inputs
+	req := multiDayRequest{
+		Inputs: defaultSimulationInputs(),
+		Site:   defaultSolarSite(),
+		Days:   4,
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
 
-	//if flag is simulate run sim
-	if *mode == "simulate" {
-		runSimulation()
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: err.Error()})
 		return
 	}
-	//find cruise speed
-	optimalCruiseSpeed = computeOptimalSpeed()
-	//empty router (router is meant to map url to handler)
-	mux := http.NewServeMux() //request router (empty --> no route to go), serve multiplexer -->takes http requests and routes it
-	mux.HandleFunc("/defaults", defaultsHandler)
-	mux.HandleFunc("/distance", distanceHandler) // handler that router directs oncoming requests
-	mux.HandleFunc("/simulate", simulateHandler)
-	mux.HandleFunc("/track", trackHandler)
-	mux.HandleFunc("/track/telemetry", trackTelemetryHandler)
+	if err := validateSolarSite(req.Site); err != nil {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: err.Error()})
+		return
+	}
+	if req.Days <= 0 || req.Days > maxForecastDays {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: fmt.Sprintf("days must be between 1 and %d", maxForecastDays)})
+		return
+	}
+	loc, err := time.LoadLocation(req.Site.Timezone)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: "invalid site timezone"})
+		return
+	}
+	now := time.Now().In(loc)
+	firstDay := now
+	if req.StartDate != "" {
+		firstDay, err = time.ParseInLocation("2006-01-02", req.StartDate, loc)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: "startDate must be YYYY-MM-DD"})
+			return
+		}
+	}
+	if err := selectWeatherForDays(&req.Site, firstDay, req.Days, now); err != nil {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: err.Error()})
+		return
+	}
+	startBatteryWh := req.Inputs.BatteryWh
+	if req.StartBatteryWh != nil {
+		startBatteryWh = math.Max(0, math.Min(*req.StartBatteryWh, req.Inputs.BatteryWh))
+	}
 
-	log.Printf("listening on %s", *addr) //%s is replaced with dereferenced addr
+	schedules, err := multiDaySchedules(firstDay, req.Days, req.Inputs.RaceDayMin)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, multiDayResponse{OK: false, Message: err.Error()})
+		return
+	}
 
-	//handles errors
-	if err := http.ListenAndServe(*addr, mux); err != nil {
-		log.Fatal(err)
+	lapLength := getTotalLength(telemetryTrackFromSegments(defaultTrackSegments()))
+	days, err := simulateMultiDay(req.Site, schedules, req.Inputs, startBatteryWh, lapLength)
+	if err != nil {
+		writeJSON(w, weatherErrorStatus(err), multiDayResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	resp := multiDayResponse{Days: days, OK: true}
+	for _, day := range days {
+		resp.TotalDistanceM += day.DistanceM
+		resp.TotalLaps += day.Laps
 	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
-// is the HTTP handler
-// w --> is outgoing http response (write)
-// r --> incoming http request. pointer to struct with everything client sent (read)
-func distanceHandler(w http.ResponseWriter, r *http.Request) {
+// raceLapsHandler runs the whole race lap by lap at the optimal cruise speed
+// and returns the per-lap SOC table.
+func raceLapsHandler(w http.ResponseWriter, r *http.Request) {
 	addCORSHeaders(w)
-	//check to see if OPTIONS request then do nothing (this happens before API request)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	//handler is called again (two http requests are made)
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	req := defaultSimulationInputs() // prefill with backend defaults, then let JSON override provided fields
-	dec := json.NewDecoder(r.Body)   //decode JSON and read
-	dec.DisallowUnknownFields()      //decoding will fail if JSON has fields that are not valid
+	site := defaultSolarSite()
+	req := raceLapsRequest{Inputs: defaultSimulationInputs(), Site: &site}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: "invalid JSON body"})
+		writeJSON(w, http.StatusBadRequest, raceLapsResponse{OK: false, Message: "invalid JSON body"})
 		return
 	}
 
-	if err := validateSimulationInputs(req); err != nil {
-		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: err.Error()})
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, raceLapsResponse{OK: false, Message: err.Error()})
 		return
 	}
-	//compute optimal cruise speed for these inputs
-	req.V = computeOptimalSpeedForInputs(req)
-	//run sim if everything is valid
-	distance, ok := distanceForInputs(req)
-	if !ok {
-		writeJSON(w, http.StatusBadRequest, distanceResponse{OK: false, Message: "inputs are not feasible for the model"})
+
+	if req.Inputs.RaceDayMin > maxRaceWindowMin {
+		writeJSON(w, http.StatusBadRequest, raceLapsResponse{OK: false, Message: fmt.Sprintf("raceDayMin must be at most %d", maxRaceWindowMin)})
+		return
+	}
+
+	solar, err := raceSolarForRequest(req.Site, req.StartTime, req.Inputs.RaceDayMin)
+	if err != nil {
+		writeJSON(w, weatherErrorStatus(err), raceLapsResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	req.Inputs.V = computeOptimalSpeedForInputs(req.Inputs)
+	race, err := simulateRaceLaps(defaultTrackSegments(), req.Inputs, solar)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, raceLapsResponse{OK: false, Message: err.Error()})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, distanceResponse{DistanceM: distance, OptimalV: req.V, RemainingEnergyWh: remainingEnergyForInputs(req), OK: true})
+	writeJSON(w, http.StatusOK, raceLapsResponse{raceLapsResult: race, OptimalV: req.Inputs.V, OK: true})
 }
 
-func simulateHandler(w http.ResponseWriter, r *http.Request) {
+// segmentStrategyHandler plans a target speed for every track segment and
+// compares the resulting race with holding the optimal cruise speed.
+func segmentStrategyHandler(w http.ResponseWriter, r *http.Request) {
 	addCORSHeaders(w)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusNoContent)
@@ -151,38 +1258,106 @@ func simulateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := simulateRequest{
-		Inputs:     defaultSimulationInputs(),
-		Wraparound: true,
-	}
+	req := segmentStrategyRequest{Inputs: defaultSimulationInputs()}
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: "invalid JSON body"})
+		writeJSON(w, http.StatusBadRequest, segmentStrategyResponse{OK: false, Message: "invalid JSON body"})
 		return
 	}
 
 	if err := validateSimulationInputs(req.Inputs); err != nil {
-		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: err.Error()})
+		writeJSON(w, http.StatusBadRequest, segmentStrategyResponse{OK: false, Message: err.Error()})
 		return
 	}
 
-	//compute optimal cruise speed for these inputs
-	req.Inputs.V = computeOptimalSpeedForInputs(req.Inputs)
+	result, err := optimizeSegmentSpeeds(defaultTrackSegments(), req.Inputs)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, segmentStrategyResponse{OK: false, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, segmentStrategyResponse{segmentStrategyResult: result, OK: true})
+}
 
-	distance, ok := distanceForInputs(req.Inputs)
-	if !ok {
-		writeJSON(w, http.StatusBadRequest, simulateResponse{OK: false, Message: "inputs are not feasible for the model"})
+// speedScheduleHandler plans a target speed for every time block of the race
+// against the solar curve for the race window.
+func speedScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	addCORSHeaders(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := speedScheduleRequest{Inputs: defaultSimulationInputs(), BlockMin: defaultScheduleBlockMin}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, speedScheduleResponse{OK: false, Message: "invalid JSON body"})
+		return
+	}
+
+	if err := validateSimulationInputs(req.Inputs); err != nil {
+		writeJSON(w, http.StatusBadRequest, speedScheduleResponse{OK: false, Message: err.Error()})
+		return
+	}
+	if req.BlockMin <= 0 || req.BatteryFloorWh < 0 || req.BatteryFloorWh >= req.Inputs.BatteryWh {
+		writeJSON(w, http.StatusBadRequest, speedScheduleResponse{OK: false, Message: "blockMin must be positive and batteryFloorWh below batteryWh"})
 		return
 	}
 
-	points, err := buildTelemetryForInputs(defaultTrackSegments(), req.Wraparound, req.Inputs)
+	solar, err := raceSolarForRequest(req.Site, req.StartTime, req.Inputs.RaceDayMin)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, simulateResponse{OK: false, Message: err.Error()})
+		writeJSON(w, weatherErrorStatus(err), speedScheduleResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	result, err := optimizeSpeedSchedule(req.Inputs, solar, req.BlockMin, req.BatteryFloorWh)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, speedScheduleResponse{OK: false, Message: err.Error()})
 		return
 	}
+	writeJSON(w, http.StatusOK, speedScheduleResponse{speedScheduleResult: result, OK: true})
+}
+
+// raceSolarForRequest builds the time-of-day solar curve for a race starting
+// at startTime in site's timezone. A nil site keeps the constant solar input.
+func raceSolarForRequest(site *solarSite, startTime string, raceDayMin float64) (raceSolarPower, error) {
+	if site == nil {
+		return nil, nil
+	}
+	if err := validateSolarSite(*site); err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(site.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid site timezone")
+	}
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, loc)
+	if startTime != "" {
+		start, err = time.ParseInLocation("2006-01-02T15:04", startTime, loc)
+		if err != nil {
+			return nil, fmt.Errorf("startTime must be YYYY-MM-DDTHH:MM")
+		}
+	}
 
-	writeJSON(w, http.StatusOK, simulateResponse{DistanceM: distance, OptimalV: req.Inputs.V, RemainingEnergyWh: remainingEnergyForInputs(req.Inputs), Points: points, OK: true})
+	s := *site
+	if err := selectWeatherForDays(&s, start, 1, now); err != nil {
+		return nil, err
+	}
+	// keep the default race-window orientation, moved to the requested times
+	window := defaultRaceDaySchedule(start, loc).Race
+	window.Start = start
+	window.End = start.Add(time.Duration(raceDayMin * float64(time.Minute)))
+	times, powerW, err := windowHourlyPower(s, window)
+	if err != nil {
+		return nil, err
+	}
+	return hourlySolarPower(start, times, powerW), nil
 }
 
 func validateSimulationInputs(req simulationInputs) error {
@@ -192,13 +1367,55 @@ func validateSimulationInputs(req simulationInputs) error {
 		req.AdditionalEfficiency < -100 || req.AdditionalEfficiency > 100 {
 		return fmt.Errorf("missing or invalid input values")
 	}
+	if req.MinReserveWh < 0 || req.MinReservePct < 0 || req.reserveWh() >= req.BatteryWh {
+		return fmt.Errorf("battery reserve must be non-negative and below batteryWh")
+	}
+	return nil
+}
+
+// validateSolarSite checks the array and location a solar curve is built
+// from. Zones replace panelArea/panelEff when set, so those are checked
+// through arrayZones.
+func validateSolarSite(site solarSite) error {
+	if site.Lat < -90 || site.Lat > 90 || site.Lon < -180 || site.Lon > 180 {
+		return fmt.Errorf("site lat must be within ±90 and lon within ±180")
+	}
+	if site.SystemEff <= 0 || site.SystemEff > 1 {
+		return fmt.Errorf("site systemEff must be in (0, 1]")
+	}
+	for _, zone := range site.arrayZones() {
+		if zone.Area <= 0 || zone.CellEff <= 0 || zone.CellEff > 1 {
+			return fmt.Errorf("site panel or zone area must be positive and efficiency in (0, 1]")
+		}
+	}
+	if pv := site.PV; pv != nil {
+		if pv.U0 <= 0 || pv.U1 < 0 || pv.TempCoeffPerC <= -1 || pv.TempCoeffPerC >= 1 {
+			return fmt.Errorf("site pv needs u0 > 0, u1 >= 0 and tempCoeffPerC within ±1")
+		}
+		for _, p := range pv.MPPTCurve {
+			if p.IrradianceWm2 < 0 || p.Efficiency < 0 || p.Efficiency > 1 {
+				return fmt.Errorf("site pv mpptCurve needs irradiance >= 0 and efficiency in [0, 1]")
+			}
+		}
+	}
 	return nil
 }
 
+// weatherErrorStatus answers a failed weather service with 502 and anything
+// else building the solar curve (bad site, time or archive file) with 400.
+func weatherErrorStatus(err error) int {
+	var upstream weatherSourceError
+	if errors.As(err, &upstream) {
+		return http.StatusBadGateway
+	}
+	return http.StatusBadRequest
+}
+
+// distanceForInputs runs DistanceForSpeedEV on the battery above the reserve.
 func distanceForInputs(req simulationInputs) (float64, bool) {
 	return DistanceForSpeedEV(
 		req.V,
-		req.BatteryWh, req.SolarWhPerMin, req.EtaDrive, req.RaceDayMin,
+		req.usableBatteryWh(), req.SolarWhPerMin, req.EtaDrive, req.RaceDayMin,
 		req.RWheel, req.Tmax, req.Pmax,
 		req.M, req.G, req.Crr, req.Rho, req.Cd, req.A, req.Theta, req.AdditionalEfficiency,
 	)
@@ -224,10 +1441,10 @@ func defaultsHandler(w http.ResponseWriter, r *http.Request) {
 // OPTIONS: asks for permission "what am i allowed to do?" ex. "can i send POST", "can i send JSON"
 // POST --> client sends data and then server process it
 func addCORSHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")                   //any website can make request to this backend
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS") //the frontend can make POST (API call) and OPTIONS (CORS preflight)request
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")       //frontend can send content type headers
-	w.Header().Set("Content-Type", "application/json")                   //response body is in JSON
+	w.Header().Set("Access-Control-Allow-Origin", "*")                           //any website can make request to this backend
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS") //the frontend can make POST (API call) and OPTIONS (CORS preflight)request
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")               //frontend can send content type headers
+	w.Header().Set("Content-Type", "application/json")                           //response body is in JSON
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload any) {
@@ -386,6 +1603,7 @@ const (
 	telemetryWarmupMaxLaps     = 5
 	telemetryWrapProfileLaps   = 3
 	telemetryWarmupTolerance   = 1e-3
+	telemetryMaxSpeed          = 40.0
 )
 
 func buildTelemetry(segments []trackSegment, wraparound bool) ([]telemetryPoint, error) {
@@ -444,20 +1662,20 @@ func buildTelemetryOneLapWithWraparoundForInputs(
 	inputs simulationInputs,
 ) ([]telemetryPoint, error) {
 	const (
-		stepM    = 1.0
-		muTire   = 0.9
-		maxSpeed = 40.0
-		vMin     = 0.5
+		stepM  = 1.0
+		muTire = 0.9
+		vMin   = 0.5
 	)
 
 	track := telemetryTrackFromSegments(segments)
-	cruiseCap := math.Min(maxSpeed, inputs.V)
+	cruiseCap := math.Min(telemetryMaxSpeed, inputs.V)
 	if cruiseCap <= 0 {
-		cruiseCap = maxSpeed
+		cruiseCap = telemetryMaxSpeed
 	}
 	profiles, err := buildTelemetryProfiles(
 		track,
 		wraparound,
+		inputs.SpeedPlan,
 		stepM,
 		inputs.Gmax,
 		cruiseCap,
@@ -485,12 +1703,27 @@ func buildTelemetryOneLapWithWraparoundForInputs(
 	v := startSpeed
 	distance := 0.0
 	profileIdx := 0
-	points = append(points, telemetryPoint{X: x, Y: y, Speed: v, Accel: 0, Distance: distance})
+	elapsed, usedWh := 0.0, 0.0
+	// advance books the time and energy of one step from v to vNext over ds
+	// and returns the wheel and battery power over it.
+	advance := func(v, vNext, ds float64) (float64, float64) {
+		vAvg := 0.5 * (v + vNext)
+		if vAvg <= 0 {
+			return 0, 0
+		}
+		dt := ds / vAvg
+		wheelW := stepTractionForce(v, vNext, ds, inputs) * vAvg
+		batteryW := math.Max(wheelW, 0) / inputs.EtaDrive
+		elapsed += dt
+		usedWh += batteryW * dt / 3600.0
+		return wheelW, batteryW
+	}
+	points = append(points, telemetryPoint{X: x, Y: y, Speed: v, Accel: 0, Distance: distance, Heading: heading})
 	// save the initial pose so we can convincingly close the lap later if
 	// numerical sampling leaves a small gap between the end and the start.
 	initialX, initialY := x, y
 
-	for _, seg := range segments {
+	for segIdx, seg := range segments {
 		switch seg.Type {
 		//when we are dealing with a straight segment
 		case "straight":
@@ -551,7 +1784,12 @@ func buildTelemetryOneLapWithWraparoundForInputs(
 				x += ds * math.Cos(heading)
 				y += ds * math.Sin(heading)
 				distance += ds
-				points = append(points, telemetryPoint{X: x, Y: y, Speed: vNext, Accel: a, Distance: distance, CurveSpeedCap: 0})
+				wheelW, batteryW := advance(v, vNext, ds)
+				points = append(points, telemetryPoint{
+					X: x, Y: y, Speed: vNext, Accel: a, Distance: distance, CurveSpeedCap: 0,
+					ElapsedS: elapsed, WheelPowerW: wheelW, BatteryPowerW: batteryW, EnergyUsedWh: usedWh,
+					Heading: heading, Segment: segIdx,
+				})
 				v = vNext
 				remaining -= ds
 				profileIdx++
@@ -562,7 +1800,10 @@ func buildTelemetryOneLapWithWraparoundForInputs(
 			}
 			if seg.Radius == 0 {
 				heading += seg.Angle * math.Pi / 180.0
-				points = append(points, telemetryPoint{X: x, Y: y, Speed: v, Accel: 0, Distance: distance, CurveSpeedCap: 0})
+				points = append(points, telemetryPoint{
+					X: x, Y: y, Speed: v, Accel: 0, Distance: distance, CurveSpeedCap: 0,
+					ElapsedS: elapsed, EnergyUsedWh: usedWh, Heading: heading, Segment: segIdx,
+				})
 				continue
 			}
 			aLatMax := inputs.Gmax * inputs.G
@@ -653,7 +1894,16 @@ func buildTelemetryOneLapWithWraparoundForInputs(
 				if vNext > brakeSpeed {
 					vNext = brakeSpeed
 				}
-				points = append(points, telemetryPoint{X: x, Y: y, Speed: vNext, Accel: a, Distance: distance, CurveSpeedCap: vCap})
+				wheelW, batteryW := advance(v, vNext, ds)
+				aLatOut := vNext * vNext / seg.Radius
+				if isRight {
+					aLatOut = -aLatOut
+				}
+				points = append(points, telemetryPoint{
+					X: x, Y: y, Speed: vNext, Accel: a, Distance: distance, CurveSpeedCap: vCap,
+					ElapsedS: elapsed, WheelPowerW: wheelW, BatteryPowerW: batteryW, EnergyUsedWh: usedWh,
+					LateralAccel: aLatOut, Heading: heading, Segment: segIdx,
+				})
 				v = vNext
 				remaining -= ds
 				profileIdx++
@@ -706,6 +1956,7 @@ func repeatTrack(track Track, laps int) Track {
 func buildTelemetryProfiles(
 	track Track,
 	wraparound bool,
+	segmentCaps []float64,
 	stepM float64,
 	gmax float64,
 	cruiseCap float64,
@@ -722,12 +1973,12 @@ func buildTelemetryProfiles(
 ) (profileSet, error) {
 	samples := sampleTrackMeters(track, stepM, g, gmax)
 	if !wraparound || len(samples) == 0 {
-		return buildProfiles(samples, cruiseCap, maxBrakeMPS2, vMin, m, g, Crr, rho, Cd, A, theta, additionalEfficiency), nil
+		return buildProfilesWithSegmentCaps(samples, segmentCaps, cruiseCap, maxBrakeMPS2, vMin, m, g, Crr, rho, Cd, A, theta, additionalEfficiency), nil
 	}
 
 	wrappedTrack := repeatTrack(track, telemetryWrapProfileLaps)
 	wrappedSamples := sampleTrackMeters(wrappedTrack, stepM, g, gmax)
-	wrappedProfiles := buildProfiles(wrappedSamples, cruiseCap, maxBrakeMPS2, vMin, m, g, Crr, rho, Cd, A, theta, additionalEfficiency)
+	wrappedProfiles := buildProfilesWithSegmentCaps(wrappedSamples, segmentCaps, cruiseCap, maxBrakeMPS2, vMin, m, g, Crr, rho, Cd, A, theta, additionalEfficiency)
 
 	oneLapCount := len(samples)
 	start := oneLapCount
@@ -871,15 +2122,17 @@ func coastDecel(
 }
 
 // remainingEnergyForInputs computes how many Wh remain in the battery at race
-// end after running at the given inputs.V cruise speed for the full race.
+// end after running at the given inputs.V cruise speed for the full race. The
+// reserve is never drawn, so a battery-limited race ends at the reserve.
 func remainingEnergyForInputs(inputs simulationInputs) float64 {
 	v := inputs.V
 	if v <= 0 || inputs.EtaDrive <= 0 {
 		return inputs.BatteryWh
 	}
+	reserveWh := inputs.reserveWh()
 	Preq := PowerRequired(v, inputs.M, inputs.G, inputs.Crr, inputs.Rho, inputs.Cd, inputs.A, inputs.Theta, inputs.AdditionalEfficiency)
 	Tsec := inputs.RaceDayMin * 60.0
-	EbattWheelJ := inputs.BatteryWh * 3600.0 * inputs.EtaDrive
+	EbattWheelJ := inputs.usableBatteryWh() * 3600.0 * inputs.EtaDrive
 	PsolarWheel := inputs.SolarWhPerMin * 60.0 * inputs.EtaDrive
 
 	// Solar alone covers all demand — battery fully intact
@@ -892,64 +2145,20 @@ func remainingEnergyForInputs(inputs simulationInputs) float64 {
 	if tEnd > Tsec {
 		// Time-limited: battery not fully depleted
 		remainingJ := EbattWheelJ - drain*Tsec
-		return remainingJ / (3600.0 * inputs.EtaDrive)
+		return reserveWh + remainingJ/(3600.0*inputs.EtaDrive)
 	}
-	// Battery-depleted before time is up
-	return 0
+	// Battery-depleted down to the reserve before time is up
+	return reserveWh
 }
 
-// computeOptimalSpeedForInputs sweeps cruise speed from 2–40 m/s, then refines
-// ±2 m/s around the coarse best at 0.1 m/s resolution to find the speed that
-// maximises distance for the given energy budget and race time.
+// computeOptimalSpeedForInputs returns the cruise speed that maximises
+// DistanceForSpeedEV, or 0 when no speed is feasible.
 func computeOptimalSpeedForInputs(inputs simulationInputs) float64 {
-	bestV, bestD := 0.0, 0.0
-	for v := 2.0; v <= 40.0; v += 0.5 {
-		if d, ok := DistanceForSpeedEV(
-			v,
-			inputs.BatteryWh,
-			inputs.SolarWhPerMin,
-			inputs.EtaDrive,
-			inputs.RaceDayMin,
-			inputs.RWheel,
-			inputs.Tmax,
-			inputs.Pmax,
-			inputs.M,
-			inputs.G,
-			inputs.Crr,
-			inputs.Rho,
-			inputs.Cd,
-			inputs.A,
-			inputs.Theta,
-			inputs.AdditionalEfficiency,
-		); ok && d > bestD {
-			bestD, bestV = d, v
-		}
-	}
-
-	for v := math.Max(0.5, bestV-2.0); v <= bestV+2.0; v += 0.1 {
-		if d, ok := DistanceForSpeedEV(
-			v,
-			inputs.BatteryWh,
-			inputs.SolarWhPerMin,
-			inputs.EtaDrive,
-			inputs.RaceDayMin,
-			inputs.RWheel,
-			inputs.Tmax,
-			inputs.Pmax,
-			inputs.M,
-			inputs.G,
-			inputs.Crr,
-			inputs.Rho,
-			inputs.Cd,
-			inputs.A,
-			inputs.Theta,
-			inputs.AdditionalEfficiency,
-		); ok && d > bestD {
-			bestD, bestV = d, v
-		}
+	search, err := optimalSpeedSearch(inputs, defaultSpeedSearchOptions())
+	if err != nil {
+		return 0
 	}
-
-	return bestV
+	return search.X
 }
 
 // computeOptimalSpeed returns the optimal cruise speed for the default preset.
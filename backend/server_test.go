@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSimulateHandlerReturnsDistanceAndTelemetry(t *testing.T) {
@@ -40,6 +44,9 @@ func TestSimulateHandlerReturnsDistanceAndTelemetry(t *testing.T) {
 	if len(got.Points) == 0 {
 		t.Fatal("expected telemetry points")
 	}
+	if got.LapsCompleted <= 0 || got.LapTimeS <= 0 || got.LapEnergyWh <= 0 {
+		t.Fatalf("got laps=%d lapTime=%.3f lapEnergy=%.3f, want positive lap stats", got.LapsCompleted, got.LapTimeS, got.LapEnergyWh)
+	}
 }
 
 func TestDistanceForInputsUsesAdditionalEfficiency(t *testing.T) {
@@ -69,3 +76,120 @@ func TestDistanceForInputsUsesAdditionalEfficiency(t *testing.T) {
 		)
 	}
 }
+
+func TestDistanceForInputsKeepsBatteryReserve(t *testing.T) {
+	reserved := defaultSimulationInputs()
+	reserved.MinReserveWh = 1000
+	reserved.V = computeOptimalSpeedForInputs(reserved)
+
+	smaller := defaultSimulationInputs()
+	smaller.BatteryWh -= 1000
+	smaller.V = computeOptimalSpeedForInputs(smaller)
+
+	got, ok := distanceForInputs(reserved)
+	if !ok {
+		t.Fatal("expected reserved distance calculation to be feasible")
+	}
+	want, _ := distanceForInputs(smaller)
+	if math.Abs(got-want) > 1e-6 {
+		t.Fatalf("got %.6f m with a 1000 Wh reserve, want %.6f m as with a 1000 Wh smaller pack", got, want)
+	}
+	// the optimum sits where the battery just lasts the race, so only the
+	// speed tolerance separates it from the reserve
+	if remaining := remainingEnergyForInputs(reserved); remaining < 1000 || remaining > 1001 {
+		t.Fatalf("got %.6f Wh remaining at the optimal speed, want about the 1000 Wh reserve", remaining)
+	}
+}
+
+func TestReserveWhUsesTheLargerOfWhAndPercent(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.BatteryWh = 5000
+	inputs.MinReserveWh = 300
+	inputs.MinReservePct = 10
+	if got := inputs.reserveWh(); got != 500 {
+		t.Fatalf("got reserve %.3f Wh, want 500", got)
+	}
+	inputs.MinReservePct = 100
+	if err := validateSimulationInputs(inputs); err == nil {
+		t.Fatal("expected a reserve of the whole pack to be rejected")
+	}
+}
+
+func TestMultiDayHandlerRunsEachDayForRaceDayMin(t *testing.T) {
+	stubHourlyGTI(t, time.Now().UTC(), time.UTC, 3, 500)
+	inputs := defaultSimulationInputs()
+	inputs.RaceDayMin = 240
+	body, err := json.Marshal(map[string]any{"inputs": inputs, "site": testSolarSite(), "days": 2})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/multiday", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	multiDayHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got multiDayResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || len(got.Days) != 2 {
+		t.Fatalf("got ok=%v with %d days (%q), want 2 days", got.OK, len(got.Days), got.Message)
+	}
+	if total := got.Days[0].DistanceM + got.Days[1].DistanceM; math.Abs(got.TotalDistanceM-total) > 1e-6 {
+		t.Fatalf("got total %.3f m, want the sum of the days %.3f m", got.TotalDistanceM, total)
+	}
+	if raceMin := got.Days[0].RaceSolarWh / got.Days[0].RaceSolarWhPerMin; math.Abs(raceMin-240) > 1e-6 {
+		t.Fatalf("got a %.1f minute race, want raceDayMin 240", raceMin)
+	}
+}
+
+func TestMultiDayHandlerRejectsZeroDays(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/multiday", bytes.NewReader([]byte(`{"days":0}`)))
+	rec := httptest.NewRecorder()
+
+	multiDayHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+}
+
+func TestMultiDayHandlerRejectsInvalidSite(t *testing.T) {
+	for _, body := range []string{
+		`{"days":1,"site":{"lat":29.65,"lon":-82.32,"timezone":"UTC","panelArea":4,"panelEff":2,"systemEff":1}}`,
+		`{"days":1,"site":{"lat":129.65,"lon":-82.32,"timezone":"UTC","panelArea":4,"panelEff":0.25,"systemEff":1}}`,
+		`{"days":1,"site":{"lat":29.65,"lon":-82.32,"timezone":"UTC","systemEff":1,"zones":[{"name":"top","area":0,"cellEff":0.25}]}}`,
+		`{"days":1,"site":{"lat":29.65,"lon":-82.32,"timezone":"UTC","panelArea":4,"panelEff":0.25,"systemEff":1,"pv":{"tempCoeffPerC":-0.0035,"u0":0,"u1":6.84}}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/multiday", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+
+		multiDayHandler(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d, want %d: %s", body, rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+	}
+}
+
+func TestMultiDayHandlerReportsWeatherFailureAsBadGateway(t *testing.T) {
+	prev := fetchHourlyWeather
+	t.Cleanup(func() { fetchHourlyWeather = prev })
+	fetchHourlyWeather = func(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDays int) (HourlyWeather, error) {
+		return HourlyWeather{}, fmt.Errorf("open-meteo error: 503 Service Unavailable")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/multiday", strings.NewReader(`{"days":1}`))
+	rec := httptest.NewRecorder()
+
+	multiDayHandler(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body.String())
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -11,16 +12,38 @@ import (
 
 type OpenMeteoResponse struct {
 	Hourly struct {
-		Time []string  `json:"time"`
-		GTI  []float64 `json:"global_tilted_irradiance_instant"`
+		Time        []string  `json:"time"`
+		GTI         []float64 `json:"global_tilted_irradiance_instant"`
+		Temperature []float64 `json:"temperature_2m"`
+		WindSpeed   []float64 `json:"wind_speed_10m"`
 	} `json:"hourly"`
 }
 
+// HourlyWeather is an hourly series of the conditions the array sees.
+// All slices share the Times index.
+type HourlyWeather struct {
+	Times   []time.Time
+	GTI     []float64 // W/m² on the tilted plane
+	TempC   []float64 // ambient air temperature at 2 m [°C]
+	WindMPS []float64 // wind speed at 10 m [m/s]
+}
+
 // FetchHourlyGTI calls Open-Meteo and returns hourly timestamps + GTI values (W/m²).
 // tiltDeg: panel tilt in degrees
 // azimuthDeg: panel azimuth (Open-Meteo convention: 0=south, -90=east, 90=west, ±180=north)
 func FetchHourlyGTI(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDays int) ([]time.Time, []float64, error) {
-	
+	weather, err := FetchHourlyWeather(lat, lon, tiltDeg, azimuthDeg, tz, forecastDays)
+	if err != nil {
+		return nil, nil, err
+	}
+	return weather.Times, weather.GTI, nil
+}
+
+// FetchHourlyWeather calls Open-Meteo and returns hourly GTI together with the
+// ambient temperature and wind speed needed to estimate cell temperature.
+// tiltDeg/azimuthDeg follow the same convention as FetchHourlyGTI.
+func FetchHourlyWeather(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDays int) (HourlyWeather, error) {
+
 	// sets up base url (query parameter builder)
 	base, _ := url.Parse("https://api.open-meteo.com/v1/forecast")
 	q := base.Query()
@@ -28,7 +51,8 @@ func FetchHourlyGTI(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDa
 	// adds parameters to url to locate car
 	q.Set("latitude", strconv.FormatFloat(lat, 'f', 6, 64))
 	q.Set("longitude", strconv.FormatFloat(lon, 'f', 6, 64))
-	q.Set("hourly", "global_tilted_irradiance_instant")
+	q.Set("hourly", "global_tilted_irradiance_instant,temperature_2m,wind_speed_10m")
+	q.Set("wind_speed_unit", "ms")
 
 	// car panel tilt
 	q.Set("tilt", strconv.FormatFloat(tiltDeg, 'f', 2, 64))
@@ -47,25 +71,31 @@ func FetchHourlyGTI(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDa
 	client := &http.Client{Timeout: 15 * time.Second}
 	resp, err := client.Get(base.String())
 	if err != nil {
-		return nil, nil, err
+		return HourlyWeather{}, err
 	}
 	defer resp.Body.Close()
 
 	// check for api errors
 	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("open-meteo error: %s", resp.Status)
+		return HourlyWeather{}, fmt.Errorf("open-meteo error: %s", resp.Status)
 	}
 
+	return decodeOpenMeteoWeather(resp.Body, tz)
+}
+
+// decodeOpenMeteoWeather reads an Open-Meteo hourly JSON body (forecast or
+// archive) and parses its timestamps in tz.
+func decodeOpenMeteoWeather(r io.Reader, tz string) (HourlyWeather, error) {
 	// reads JSON and converts to Open-Meteo Response struct
 	var om OpenMeteoResponse
-	if err := json.NewDecoder(resp.Body).Decode(&om); err != nil {
-		return nil, nil, err
+	if err := json.NewDecoder(r).Decode(&om); err != nil {
+		return HourlyWeather{}, err
 	}
 
 	// Parse times as local timestamps in the provided timezone.
 	loc, err := time.LoadLocation(tz)
 	if err != nil {
-		return nil, nil, err
+		return HourlyWeather{}, err
 	}
 
 	times := make([]time.Time, 0, len(om.Hourly.Time))
@@ -73,12 +103,17 @@ func FetchHourlyGTI(lat, lon, tiltDeg, azimuthDeg float64, tz string, forecastDa
 		// Open-Meteo hourly time strings look like: "2026-01-12T14:00"
 		t, err := time.ParseInLocation("2006-01-02T15:04", ts, loc)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse time %q: %w", ts, err)
+			return HourlyWeather{}, fmt.Errorf("failed to parse time %q: %w", ts, err)
 		}
 		times = append(times, t)
 	}
 
-	return times, om.Hourly.GTI, nil
+	return HourlyWeather{
+		Times:   times,
+		GTI:     om.Hourly.GTI,
+		TempC:   om.Hourly.Temperature,
+		WindMPS: om.Hourly.WindSpeed,
+	}, nil
 }
 
 // Utilizes Open-Meteo Hourly GTI to calculate Watts
@@ -109,11 +144,38 @@ func BuildEnergyWithBattery(
 ) (totalEnergyWh float64, newBatteryWh float64, err error) {
 
 	// 1) Fetch data
-	times, gti, err := FetchHourlyGTI(lat, lon, tiltDeg, azimuthDeg, timezone, forecastDays)
+	times, gti, err := fetchHourlyGTI(lat, lon, tiltDeg, azimuthDeg, timezone, forecastDays)
 	if err != nil {
 		return 0, initialBatteryWh, err
 	}
 
+	totalEnergy, batteryWh := energyFromGTISeries(times, gti, panelArea, panelEff, systemEff, dt, initialBatteryWh, spanStart, spanEnd)
+	return totalEnergy, batteryWh, nil
+}
+
+// fetchHourlyGTI is the GTI source used by the energy builders. Tests swap it
+// for a canned series so they don't depend on the network.
+var fetchHourlyGTI = FetchHourlyGTI
+
+// fetchHourlyWeather is the weather source used when a pvModel is configured.
+var fetchHourlyWeather = FetchHourlyWeather
+
+// energyFromGTISeries integrates an hourly GTI series into energy gained and the
+// resulting battery level over the optional [spanStart, spanEnd) window.
+func energyFromGTISeries(
+	times []time.Time,
+	gti []float64,
+
+	panelArea float64,
+	panelEff float64,
+	systemEff float64,
+
+	dt time.Duration,
+	initialBatteryWh float64,
+
+	spanStart *time.Time,
+	spanEnd *time.Time,
+) (totalEnergyWh float64, newBatteryWh float64) {
 	dtHours := dt.Hours()
 	batteryWh := initialBatteryWh
 	totalEnergy := 0.0
@@ -144,5 +206,5 @@ func BuildEnergyWithBattery(
 		batteryWh += energyWh
 	}
 
-	return totalEnergy, batteryWh, nil
+	return totalEnergy, batteryWh
 }
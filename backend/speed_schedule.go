@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultScheduleBlockMin = 15.0
+	scheduleSpeedStepMPS    = 0.25
+	scheduleMinSpeedMPS     = 2.0
+	scheduleBatteryLevels   = 400 // battery states between the floor and a full pack
+)
+
+// speedBlock is one time block of the race schedule, replayed from a full
+// pack with the block's target speed held throughout.
+type speedBlock struct {
+	Block       int     `json:"block"`
+	StartS      float64 `json:"startS"`
+	DurationS   float64 `json:"durationS"`
+	TargetSpeed float64 `json:"targetSpeed"` // m/s
+	SolarWh     float64 `json:"solarWh"`
+	EnergyWh    float64 `json:"energyWh"`  // drawn from the battery by the drivetrain
+	BatteryWh   float64 `json:"batteryWh"` // at the end of the block
+	SOC         float64 `json:"soc"`       // % of inputs.BatteryWh at the end of the block
+	DistanceM   float64 `json:"distanceM"`
+}
+
+// speedScheduleResult is a time-of-day speed schedule and how it compares
+// with the best single speed that keeps the same battery floor.
+type speedScheduleResult struct {
+	Blocks            []speedBlock `json:"blocks"`
+	DistanceM         float64      `json:"distanceM"`
+	RemainingEnergyWh float64      `json:"remainingEnergyWh"`
+	MinBatteryWh      float64      `json:"minBatteryWh"` // lowest end-of-block battery
+	BatteryFloorWh    float64      `json:"batteryFloorWh"`
+	ConstantV         float64      `json:"constantV"`
+	ConstantDistanceM float64      `json:"constantDistanceM"`
+}
+
+// cruiseBatteryPowerW returns the battery power [W] needed to hold v on the
+// flat-road model, and false when the drivetrain cannot hold it (the same
+// feasibility check as DistanceForSpeedEV).
+func cruiseBatteryPowerW(v float64, inputs simulationInputs) (float64, bool) {
+	p := PowerRequired(v, inputs.M, inputs.G, inputs.Crr, inputs.Rho, inputs.Cd, inputs.A, inputs.Theta, inputs.AdditionalEfficiency)
+	if p <= 0 || math.IsNaN(p) || math.IsInf(p, 0) {
+		return 0, false
+	}
+	if WheelPowerEV(v, inputs.Tmax, inputs.Pmax, inputs.RWheel, inputs.EtaDrive)+1e-9 < p {
+		return 0, false
+	}
+	return p / inputs.EtaDrive, true
+}
+
+// scheduleBlocks splits the race window into blockMin blocks; the last block
+// is shorter when the window does not divide evenly.
+func scheduleBlocks(raceDayMin, blockMin float64) []speedBlock {
+	raceS, blockS := raceDayMin*60.0, blockMin*60.0
+	var blocks []speedBlock
+	for start := 0.0; start < raceS-1e-9; start += blockS {
+		blocks = append(blocks, speedBlock{Block: len(blocks), StartS: start, DurationS: math.Min(blockS, raceS-start)})
+	}
+	return blocks
+}
+
+// replaySpeedSchedule drives speeds block by block from a full pack and
+// fills in each block's energy, battery and distance. Solar beyond a full
+// pack is lost. It returns false if the battery drops below floorWh.
+func replaySpeedSchedule(blocks []speedBlock, speeds []float64, inputs simulationInputs, solar raceSolarPower, floorWh float64) (speedScheduleResult, bool) {
+	result := speedScheduleResult{BatteryFloorWh: floorWh, MinBatteryWh: inputs.BatteryWh}
+	batteryWh := inputs.BatteryWh
+	ok := true
+	for i, block := range blocks {
+		powerW, feasible := cruiseBatteryPowerW(speeds[i], inputs)
+		if !feasible {
+			return speedScheduleResult{}, false
+		}
+		block.TargetSpeed = speeds[i]
+		block.SolarWh = solarEnergyBetween(solar, block.StartS, block.StartS+block.DurationS)
+		block.EnergyWh = powerW * block.DurationS / 3600.0
+		batteryWh = math.Min(inputs.BatteryWh, batteryWh-block.EnergyWh+block.SolarWh)
+		block.BatteryWh = batteryWh
+		block.SOC = batteryWh / inputs.BatteryWh * 100
+		block.DistanceM = speeds[i] * block.DurationS
+
+		if batteryWh < floorWh-1e-9 {
+			ok = false
+		}
+		result.MinBatteryWh = math.Min(result.MinBatteryWh, batteryWh)
+		result.DistanceM += block.DistanceM
+		result.Blocks = append(result.Blocks, block)
+	}
+	result.RemainingEnergyWh = batteryWh
+	return result, ok
+}
+
+// optimizeSpeedSchedule picks a target speed for every blockMin block of the
+// race to maximise distance while the battery never ends a block below
+// floorWh, or the inputs reserve when that is higher. With a flat solar
+// curve one speed is optimal; the schedule only departs from it where the
+// pack would otherwise fill up (spend the surplus while the sun is strong)
+// or hit the floor (slow down until the sun returns).
+//
+// It is a dynamic program over blocks and battery level: the battery is
+// rounded down to one of scheduleBatteryLevels states after every block, so
+// the plan is conservative and the exact replay always keeps the floor.
+func optimizeSpeedSchedule(inputs simulationInputs, solar raceSolarPower, blockMin, floorWh float64) (speedScheduleResult, error) {
+	if blockMin <= 0 {
+		blockMin = defaultScheduleBlockMin
+	}
+	if floorWh < 0 || floorWh >= inputs.BatteryWh {
+		return speedScheduleResult{}, fmt.Errorf("battery floor must be between 0 and the battery capacity")
+	}
+	if solar == nil {
+		solar = constantSolarPower(inputs)
+	}
+	floorWh = math.Max(floorWh, inputs.reserveWh())
+	blocks := scheduleBlocks(inputs.RaceDayMin, blockMin)
+	if len(blocks) == 0 {
+		return speedScheduleResult{}, fmt.Errorf("missing or invalid input values")
+	}
+
+	var speeds, powers []float64
+	for v := scheduleMinSpeedMPS; v <= telemetryMaxSpeed+1e-9; v += scheduleSpeedStepMPS {
+		if p, ok := cruiseBatteryPowerW(v, inputs); ok {
+			speeds = append(speeds, v)
+			powers = append(powers, p)
+		}
+	}
+	if len(speeds) == 0 {
+		return speedScheduleResult{}, fmt.Errorf("no feasible cruise speed")
+	}
+
+	levelWh := (inputs.BatteryWh - floorWh) / scheduleBatteryLevels
+	levelOf := func(batteryWh float64) int {
+		return int(math.Floor((batteryWh-floorWh)/levelWh + 1e-9))
+	}
+	solarWh := make([]float64, len(blocks))
+	for i, block := range blocks {
+		solarWh[i] = solarEnergyBetween(solar, block.StartS, block.StartS+block.DurationS)
+	}
+
+	// best[i][l] is the most distance covered before block i arriving with
+	// battery level l; choice records the speed index that got there.
+	states := scheduleBatteryLevels + 1
+	best := make([][]float64, len(blocks)+1)
+	choice := make([][]int, len(blocks)+1)
+	from := make([][]int, len(blocks)+1)
+	for i := range best {
+		best[i] = make([]float64, states)
+		choice[i] = make([]int, states)
+		from[i] = make([]int, states)
+		for l := range best[i] {
+			best[i][l] = math.Inf(-1)
+		}
+	}
+	best[0][scheduleBatteryLevels] = 0
+
+	for i, block := range blocks {
+		for l, dist := range best[i] {
+			if math.IsInf(dist, -1) {
+				continue
+			}
+			batteryWh := floorWh + float64(l)*levelWh
+			for k, v := range speeds {
+				next := math.Min(inputs.BatteryWh, batteryWh-powers[k]*block.DurationS/3600.0+solarWh[i])
+				if next < floorWh {
+					break // faster speeds only drain more
+				}
+				nl := min(levelOf(next), scheduleBatteryLevels)
+				if d := dist + v*block.DurationS; d > best[i+1][nl] {
+					best[i+1][nl] = d
+					choice[i+1][nl] = k
+					from[i+1][nl] = l
+				}
+			}
+		}
+	}
+
+	endLevel, endDist := -1, math.Inf(-1)
+	for l, d := range best[len(blocks)] {
+		if d > endDist {
+			endLevel, endDist = l, d
+		}
+	}
+	if endLevel < 0 {
+		return speedScheduleResult{}, fmt.Errorf("battery floor cannot be held even at %.1f m/s", speeds[0])
+	}
+	plan := make([]float64, len(blocks))
+	for i, l := len(blocks), endLevel; i > 0; i-- {
+		plan[i-1] = speeds[choice[i][l]]
+		l = from[i][l]
+	}
+
+	result, ok := replaySpeedSchedule(blocks, plan, inputs, solar, floorWh)
+	if !ok {
+		return speedScheduleResult{}, fmt.Errorf("schedule replay dropped below the battery floor")
+	}
+
+	// best single speed under the same floor, for comparison
+	constant := make([]float64, len(blocks))
+	for _, v := range speeds {
+		for i := range constant {
+			constant[i] = v
+		}
+		flat, ok := replaySpeedSchedule(blocks, constant, inputs, solar, floorWh)
+		if !ok {
+			break
+		}
+		result.ConstantV, result.ConstantDistanceM = v, flat.DistanceM
+	}
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// middaySolarPower is a half-sine solar curve over the race window peaking at
+// peakW, with no sun at the start and end.
+func middaySolarPower(inputs simulationInputs, peakW float64) raceSolarPower {
+	raceS := inputs.RaceDayMin * 60.0
+	return func(elapsedS float64) float64 {
+		if elapsedS <= 0 || elapsedS >= raceS {
+			return 0
+		}
+		return peakW * math.Sin(math.Pi*elapsedS/raceS)
+	}
+}
+
+func TestOptimizeSpeedScheduleKeepsBatteryFloor(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.BatteryWh = 1500
+	const floorWh = 300
+
+	got, err := optimizeSpeedSchedule(inputs, middaySolarPower(inputs, 1200), 15, floorWh)
+	if err != nil {
+		t.Fatalf("optimizeSpeedSchedule returned error: %v", err)
+	}
+	if len(got.Blocks) != int(inputs.RaceDayMin/15) {
+		t.Fatalf("got %d blocks, want %d", len(got.Blocks), int(inputs.RaceDayMin/15))
+	}
+	if got.MinBatteryWh < floorWh-1e-9 {
+		t.Fatalf("got minimum battery %.3f Wh, want at least the %.0f Wh floor", got.MinBatteryWh, float64(floorWh))
+	}
+	if got.DistanceM < got.ConstantDistanceM {
+		t.Fatalf("got %.1f m, want at least the constant-speed %.1f m", got.DistanceM, got.ConstantDistanceM)
+	}
+}
+
+func TestOptimizeSpeedScheduleSpendsMiddaySun(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.BatteryWh = 800
+
+	got, err := optimizeSpeedSchedule(inputs, middaySolarPower(inputs, 1500), 15, 0)
+	if err != nil {
+		t.Fatalf("optimizeSpeedSchedule returned error: %v", err)
+	}
+	// a small pack fills up under the midday sun, so driving faster then
+	// beats any single speed
+	first, middle := got.Blocks[0].TargetSpeed, got.Blocks[len(got.Blocks)/2].TargetSpeed
+	if middle <= first {
+		t.Fatalf("got midday target %.2f m/s, want faster than the opening %.2f m/s", middle, first)
+	}
+	if got.DistanceM <= got.ConstantDistanceM {
+		t.Fatalf("got %.1f m, want more than constant-speed %.1f m", got.DistanceM, got.ConstantDistanceM)
+	}
+}
+
+func TestOptimizeSpeedScheduleRejectsFloorAboveCapacity(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	if _, err := optimizeSpeedSchedule(inputs, nil, 15, inputs.BatteryWh); err == nil {
+		t.Fatal("expected an error for a floor at the battery capacity")
+	}
+}
+
+func TestSpeedScheduleHandlerReturnsBlocks(t *testing.T) {
+	body, err := json.Marshal(speedScheduleRequest{Inputs: defaultSimulationInputs(), BlockMin: 30, BatteryFloorWh: 100})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/strategy/schedule", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	speedScheduleHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got speedScheduleResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || len(got.Blocks) != int(defaultSimulationInputs().RaceDayMin/30) {
+		t.Fatalf("got ok=%v blocks=%d, want one block per 30 minutes", got.OK, len(got.Blocks))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultSweepMinV  = 2.0
+	defaultSweepMaxV  = 40.0
+	defaultSweepStepV = 0.5
+	maxSweepPoints    = 400
+)
+
+// sweepPoint is the cruise model, and optionally the lap simulation, at one
+// speed of a sweep.
+type sweepPoint struct {
+	V                 float64          `json:"v"`
+	DistanceM         float64          `json:"distanceM"`
+	RemainingEnergyWh float64          `json:"remainingEnergyWh"`
+	Feasible          bool             `json:"feasible"`            // the drivetrain can hold V
+	LimitedBy         string           `json:"limitedBy,omitempty"` // "time" or "battery"
+	Lap               *trackRaceResult `json:"lap,omitempty"`       // lap simulation, when requested
+}
+
+// sweepSpeedCount returns how many speeds minV..maxV in stepV steps covers,
+// including both ends.
+func sweepSpeedCount(minV, maxV, stepV float64) (int, error) {
+	if minV <= 0 || maxV < minV || stepV <= 0 {
+		return 0, fmt.Errorf("sweep needs 0 < minV <= maxV and a positive stepV")
+	}
+	n := int(math.Floor((maxV-minV)/stepV+1e-9)) + 1
+	if n > maxSweepPoints {
+		return 0, fmt.Errorf("sweep has %d speeds, more than the %d allowed", n, maxSweepPoints)
+	}
+	return n, nil
+}
+
+// sweepSpeeds evaluates DistanceForSpeedEV at every speed from minV to maxV.
+// With laps set it also runs the telemetry lap at each feasible speed and
+// repeats it over the race, which is much slower.
+func sweepSpeeds(inputs simulationInputs, minV, maxV, stepV float64, laps bool) ([]sweepPoint, error) {
+	n, err := sweepSpeedCount(minV, maxV, stepV)
+	if err != nil {
+		return nil, err
+	}
+	segments := defaultTrackSegments()
+
+	points := make([]sweepPoint, 0, n)
+	for i := 0; i < n; i++ {
+		inputs.V = minV + float64(i)*stepV
+		point := sweepPoint{V: inputs.V}
+		distance, ok := distanceForInputs(inputs)
+		if !ok {
+			points = append(points, point)
+			continue
+		}
+		point.Feasible = true
+		point.DistanceM = distance
+		point.RemainingEnergyWh = remainingEnergyForInputs(inputs)
+		point.LimitedBy = "time"
+		if distance < inputs.V*inputs.RaceDayMin*60.0-1e-6 {
+			point.LimitedBy = "battery"
+		}
+
+		if laps {
+			telemetry, err := buildTelemetryForInputs(segments, true, inputs)
+			if err != nil {
+				return nil, err
+			}
+			race, err := trackRaceDistance(telemetry, inputs)
+			if err != nil {
+				return nil, err
+			}
+			point.Lap = &race
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
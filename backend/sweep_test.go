@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSweepSpeedsMatchesCruiseModel(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	points, err := sweepSpeeds(inputs, 10, 30, 5, false)
+	if err != nil {
+		t.Fatalf("sweepSpeeds returned error: %v", err)
+	}
+	if len(points) != 5 {
+		t.Fatalf("got %d points, want 5", len(points))
+	}
+	for _, p := range points {
+		inputs.V = p.V
+		want, _ := distanceForInputs(inputs)
+		if !p.Feasible || math.Abs(p.DistanceM-want) > 1e-9 {
+			t.Fatalf("at %.1f m/s: got feasible=%v distance %.3f m, want %.3f", p.V, p.Feasible, p.DistanceM, want)
+		}
+		if p.Lap != nil {
+			t.Fatalf("at %.1f m/s: got a lap result without laps requested", p.V)
+		}
+	}
+	// distance grows with speed until the battery runs out, then falls
+	if points[0].LimitedBy != "time" || points[len(points)-1].LimitedBy != "battery" {
+		t.Fatalf("got limitedBy %q at %.1f m/s and %q at %.1f m/s, want time then battery",
+			points[0].LimitedBy, points[0].V, points[len(points)-1].LimitedBy, points[len(points)-1].V)
+	}
+}
+
+func TestSweepSpeedsRejectsOversizedSweep(t *testing.T) {
+	if _, err := sweepSpeeds(defaultSimulationInputs(), 1, 100, 0.01, false); err == nil {
+		t.Fatal("expected an error for a sweep over the point limit")
+	}
+}
+
+func TestSweepHandlerRunsLaps(t *testing.T) {
+	body, err := json.Marshal(sweepRequest{Inputs: defaultSimulationInputs(), MinV: 20, MaxV: 24, StepV: 2, Laps: true})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/sweep", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	sweepHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var got sweepResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if !got.OK || len(got.Points) != 3 {
+		t.Fatalf("got ok=%v points=%d, want 3 points", got.OK, len(got.Points))
+	}
+	for _, p := range got.Points {
+		if p.Lap == nil || p.Lap.DistanceM <= 0 {
+			t.Fatalf("at %.1f m/s: got lap %+v, want a lap simulation", p.V, p.Lap)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const earthRadiusM = 6371000.0
+
+// raceLogSample is one row of a recorded telemetry log. Lat/Lon and
+// DistanceM are optional; without them distance comes from integrating
+// speed.
+type raceLogSample struct {
+	T         float64 `json:"t"` // s
+	Lat       float64 `json:"lat,omitempty"`
+	Lon       float64 `json:"lon,omitempty"`
+	DistanceM float64 `json:"distanceM,omitempty"`
+	Speed     float64 `json:"speed"`    // m/s
+	CurrentA  float64 `json:"currentA"` // battery current, positive when discharging
+	VoltageV  float64 `json:"voltageV"`
+	// ArrayPowerW is what the solar array delivered; optional.
+	ArrayPowerW float64 `json:"arrayPowerW,omitempty"`
+}
+
+// batteryPowerW is the power drawn from the pack; regen shows as negative.
+func (s raceLogSample) batteryPowerW() float64 {
+	return s.CurrentA * s.VoltageV
+}
+
+// gpsDistanceM is the great-circle distance between two fixes.
+func gpsDistanceM(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// logDistances returns the cumulative distance at each sample: the logged
+// DistanceM when present, else the GPS path length, else the integral of
+// speed.
+func logDistances(samples []raceLogSample) []float64 {
+	hasDistance, hasGPS := false, false
+	for _, s := range samples {
+		hasDistance = hasDistance || s.DistanceM != 0
+		hasGPS = hasGPS || s.Lat != 0 || s.Lon != 0
+	}
+	out := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		switch {
+		case hasDistance:
+			out[i] = cur.DistanceM
+			continue
+		case hasGPS:
+			out[i] = out[i-1] + gpsDistanceM(prev.Lat, prev.Lon, cur.Lat, cur.Lon)
+		default:
+			out[i] = out[i-1] + 0.5*(prev.Speed+cur.Speed)*(cur.T-prev.T)
+		}
+	}
+	if hasDistance && len(samples) > 0 {
+		out[0] = samples[0].DistanceM
+	}
+	return out
+}
+
+// hasArrayPower reports whether the log recorded the array's output.
+func hasArrayPower(samples []raceLogSample) bool {
+	for _, s := range samples {
+		if s.ArrayPowerW != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// logEnergyWh returns the cumulative drive energy at each sample: the pack
+// draw plus what the array supplied, so the curve matches the model, which
+// has no solar. The array's share is the logged ArrayPowerW when the log has
+// it and solarW otherwise. Regen is left out as the model has none.
+func logEnergyWh(samples []raceLogSample, solarW float64) []float64 {
+	logged := hasArrayPower(samples)
+	drive := func(s raceLogSample) float64 {
+		if logged {
+			return math.Max(s.batteryPowerW()+s.ArrayPowerW, 0)
+		}
+		return math.Max(s.batteryPowerW()+solarW, 0)
+	}
+	out := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		p := 0.5 * (drive(prev) + drive(cur))
+		out[i] = out[i-1] + p*(cur.T-prev.T)/3600.0
+	}
+	return out
+}
+
+// validateRaceLog checks the samples are usable and in time order.
+func validateRaceLog(samples []raceLogSample) error {
+	if len(samples) < 2 {
+		return fmt.Errorf("telemetry log needs at least 2 samples")
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].T <= samples[i-1].T {
+			return fmt.Errorf("telemetry log sample %d: time must increase", i+1)
+		}
+	}
+	return nil
+}
+
+// raceLogColumns are the CSV header names decodeRaceLogCSV understands; the
+// first group is required.
+var raceLogColumns = map[string]bool{
+	"time_s": true, "speed_mps": true, "current_a": true, "voltage_v": true,
+	"lat": false, "lon": false, "distance_m": false, "array_power_w": false,
+}
+
+// decodeRaceLogCSV reads a telemetry log whose header names its columns
+// (time_s, speed_mps, current_a, voltage_v and optionally lat, lon,
+// distance_m, array_power_w) in any order. Unknown columns are ignored.
+func decodeRaceLogCSV(r io.Reader) ([]raceLogSample, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("telemetry CSV has no data rows")
+	}
+
+	index := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := raceLogColumns[name]; ok {
+			index[name] = i
+		}
+	}
+	for name, required := range raceLogColumns {
+		if _, ok := index[name]; required && !ok {
+			return nil, fmt.Errorf("telemetry CSV is missing column %q", name)
+		}
+	}
+
+	samples := make([]raceLogSample, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		value := func(name string) (float64, error) {
+			col, ok := index[name]
+			if !ok || col >= len(row) || row[col] == "" {
+				return 0, nil
+			}
+			return strconv.ParseFloat(row[col], 64)
+		}
+		var s raceLogSample
+		for name, dst := range map[string]*float64{
+			"time_s": &s.T, "speed_mps": &s.Speed, "current_a": &s.CurrentA, "voltage_v": &s.VoltageV,
+			"lat": &s.Lat, "lon": &s.Lon, "distance_m": &s.DistanceM, "array_power_w": &s.ArrayPowerW,
+		} {
+			if *dst, err = value(name); err != nil {
+				return nil, fmt.Errorf("telemetry CSV row %d: %w", i+2, err)
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
@@ -174,3 +174,41 @@ func TestBuildTelemetryWraparoundChangesDefaultTrack(t *testing.T) {
 		t.Fatal("expected wraparound telemetry to differ from non-wrap telemetry on default track")
 	}
 }
+
+func TestBuildTelemetryEnergyChannelsMatchLapIntegration(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	inputs.V = computeOptimalSpeedForInputs(inputs)
+
+	points, err := buildTelemetryForInputs(defaultTrackSegments(), true, inputs)
+	if err != nil {
+		t.Fatalf("buildTelemetryForInputs returned error: %v", err)
+	}
+	last := points[len(points)-1]
+
+	wantTime, wantWh := lapTimeAndEnergy(points, inputs)
+	if math.Abs(last.ElapsedS-wantTime) > 1e-6 {
+		t.Fatalf("got final elapsed %.6f s, want lap time %.6f", last.ElapsedS, wantTime)
+	}
+	if math.Abs(last.EnergyUsedWh-wantWh) > 1e-6 {
+		t.Fatalf("got final energy used %.6f Wh, want %.6f", last.EnergyUsedWh, wantWh)
+	}
+
+	sawLateral := false
+	for i, p := range points {
+		if p.BatteryPowerW < 0 {
+			t.Fatalf("point %d: got negative battery power %.6f W", i, p.BatteryPowerW)
+		}
+		if p.WheelPowerW > 0 && math.Abs(p.BatteryPowerW-p.WheelPowerW/inputs.EtaDrive) > 1e-9 {
+			t.Fatalf("point %d: got battery power %.6f W, want wheel/eta %.6f", i, p.BatteryPowerW, p.WheelPowerW/inputs.EtaDrive)
+		}
+		if i > 0 && p.ElapsedS < points[i-1].ElapsedS {
+			t.Fatalf("point %d: elapsed time went backwards", i)
+		}
+		if p.CurveSpeedCap > 0 && p.LateralAccel != 0 {
+			sawLateral = true
+		}
+	}
+	if !sawLateral {
+		t.Fatal("expected lateral acceleration on curve points")
+	}
+}
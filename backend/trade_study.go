@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+const maxTradeStudyCells = 10000
+
+// tradeAxis is one varied input: Steps values evenly spaced from Min to Max.
+type tradeAxis struct {
+	Field string  `json:"field"` // simulationInputs JSON name, e.g. "cD"
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Steps int     `json:"steps"`
+}
+
+// values returns the axis sample points.
+func (a tradeAxis) values() []float64 {
+	if a.Steps == 1 {
+		return []float64{a.Min}
+	}
+	out := make([]float64, a.Steps)
+	for i := range out {
+		out[i] = a.Min + (a.Max-a.Min)*float64(i)/float64(a.Steps-1)
+	}
+	return out
+}
+
+func (a tradeAxis) validate() error {
+	if _, ok := inputFieldIndex[a.Field]; !ok {
+		return unknownInputField(a.Field)
+	}
+	if a.Steps < 1 || a.Max < a.Min {
+		return fmt.Errorf("axis %q needs steps >= 1 and min <= max", a.Field)
+	}
+	if a.Steps > maxTradeStudyCells {
+		return fmt.Errorf("axis %q has %d steps, more than the %d cells allowed", a.Field, a.Steps, maxTradeStudyCells)
+	}
+	return nil
+}
+
+// tradeStudyBest is the grid cell with the longest race distance.
+type tradeStudyBest struct {
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	I         int     `json:"i"`
+	J         int     `json:"j"`
+	DistanceM float64 `json:"distanceM"`
+	OptimalV  float64 `json:"optimalV"`
+}
+
+// tradeStudyResult holds the distance at the optimal cruise speed for every
+// (X[i], Y[j]) pair. Infeasible cells have zero distance and speed.
+type tradeStudyResult struct {
+	XField    string          `json:"xField"`
+	YField    string          `json:"yField"`
+	X         []float64       `json:"x"`
+	Y         []float64       `json:"y"`
+	DistanceM [][]float64     `json:"distanceM"` // [i][j]
+	OptimalV  [][]float64     `json:"optimalV"`  // [i][j]
+	Best      *tradeStudyBest `json:"best,omitempty"`
+}
+
+// runTradeStudy evaluates the optimal-speed distance over the x × y grid on
+// all CPUs.
+func runTradeStudy(base simulationInputs, x, y tradeAxis) (tradeStudyResult, error) {
+	if err := x.validate(); err != nil {
+		return tradeStudyResult{}, err
+	}
+	if err := y.validate(); err != nil {
+		return tradeStudyResult{}, err
+	}
+	if x.Field == y.Field {
+		return tradeStudyResult{}, fmt.Errorf("trade study needs two different fields")
+	}
+	// divide rather than multiply so huge step counts cannot overflow int
+	if x.Steps > maxTradeStudyCells/y.Steps {
+		return tradeStudyResult{}, fmt.Errorf("trade study has %d cells, more than the %d allowed", x.Steps*y.Steps, maxTradeStudyCells)
+	}
+
+	result := tradeStudyResult{XField: x.Field, YField: y.Field, X: x.values(), Y: y.values()}
+	result.DistanceM = make([][]float64, len(result.X))
+	result.OptimalV = make([][]float64, len(result.X))
+	for i := range result.X {
+		result.DistanceM[i] = make([]float64, len(result.Y))
+		result.OptimalV[i] = make([]float64, len(result.Y))
+	}
+
+	type cell struct{ i, j int }
+	cells := make(chan cell)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range cells {
+				inputs := base
+				// fields were checked by validate, so these cannot fail
+				_ = setInputField(&inputs, x.Field, result.X[c.i])
+				_ = setInputField(&inputs, y.Field, result.Y[c.j])
+				if validateSimulationInputs(inputs) != nil {
+					continue
+				}
+				search, err := optimalSpeedSearch(inputs, defaultSpeedSearchOptions())
+				if err != nil {
+					continue
+				}
+				// each goroutine owns its cell, so no lock is needed
+				result.DistanceM[c.i][c.j] = search.Value
+				result.OptimalV[c.i][c.j] = search.X
+			}
+		}()
+	}
+	for i := range result.X {
+		for j := range result.Y {
+			cells <- cell{i, j}
+		}
+	}
+	close(cells)
+	wg.Wait()
+
+	for i := range result.X {
+		for j := range result.Y {
+			if d := result.DistanceM[i][j]; d > 0 && (result.Best == nil || d > result.Best.DistanceM) {
+				result.Best = &tradeStudyBest{X: result.X[i], Y: result.Y[j], I: i, J: j, DistanceM: d, OptimalV: result.OptimalV[i][j]}
+			}
+		}
+	}
+	return result, nil
+}
+
+// writeTradeStudyCSV writes one row per grid cell:
+// <xField>,<yField>,distanceM,optimalV.
+func writeTradeStudyCSV(w io.Writer, result tradeStudyResult) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{result.XField, result.YField, "distanceM", "optimalV"}); err != nil {
+		return err
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for i, x := range result.X {
+		for j, y := range result.Y {
+			row := []string{format(x), format(y), format(result.DistanceM[i][j]), format(result.OptimalV[i][j])}
+			if err := out.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetInputFieldUsesJSONNames(t *testing.T) {
+	inputs := defaultSimulationInputs()
+	if err := setInputField(&inputs, "cD", 0.2); err != nil {
+		t.Fatalf("setInputField returned error: %v", err)
+	}
+	if got, _ := inputField(inputs, "cD"); inputs.Cd != 0.2 || got != 0.2 {
+		t.Fatalf("got Cd %.3f (read back %.3f), want 0.2", inputs.Cd, got)
+	}
+	if err := setInputField(&inputs, "v", 10); err == nil {
+		t.Fatal("expected an error for the server-computed speed")
+	}
+}
+
+func TestRunTradeStudyMatchesSerialEvaluation(t *testing.T) {
+	base := defaultSimulationInputs()
+	x := tradeAxis{Field: "cD", Min: 0.1, Max: 0.3, Steps: 3}
+	y := tradeAxis{Field: "batteryWh", Min: 2000, Max: 6000, Steps: 4}
+
+	got, err := runTradeStudy(base, x, y)
+	if err != nil {
+		t.Fatalf("runTradeStudy returned error: %v", err)
+	}
+	if len(got.DistanceM) != 3 || len(got.DistanceM[0]) != 4 {
+		t.Fatalf("got %dx%d grid, want 3x4", len(got.DistanceM), len(got.DistanceM[0]))
+	}
+	for i, cd := range got.X {
+		for j, battery := range got.Y {
+			inputs := base
+			inputs.Cd, inputs.BatteryWh = cd, battery
+			want, err := optimalSpeedSearch(inputs, defaultSpeedSearchOptions())
+			if err != nil {
+				t.Fatalf("optimalSpeedSearch returned error: %v", err)
+			}
+			if math.Abs(got.DistanceM[i][j]-want.Value) > 1e-9 {
+				t.Fatalf("cell (%d,%d): got %.3f m, want %.3f", i, j, got.DistanceM[i][j], want.Value)
+			}
+		}
+	}
+	// least drag and the biggest pack go furthest
+	if got.Best == nil || got.Best.I != 0 || got.Best.J != 3 {
+		t.Fatalf("got best %+v, want cell (0,3)", got.Best)
+	}
+}
+
+func TestRunTradeStudyRejectsUnknownField(t *testing.T) {
+	_, err := runTradeStudy(defaultSimulationInputs(), tradeAxis{Field: "wings", Min: 1, Max: 2, Steps: 2}, tradeAxis{Field: "m", Min: 200, Max: 300, Steps: 2})
+	if err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
+
+func TestRunTradeStudyRejectsOverflowingGrid(t *testing.T) {
+	// 1<<32 squared wraps to 0 in int
+	_, err := runTradeStudy(defaultSimulationInputs(), tradeAxis{Field: "cD", Min: 0.1, Max: 0.2, Steps: 1 << 32}, tradeAxis{Field: "m", Min: 200, Max: 300, Steps: 1 << 32})
+	if err == nil {
+		t.Fatal("expected an error for an oversized grid")
+	}
+	_, err = runTradeStudy(defaultSimulationInputs(), tradeAxis{Field: "cD", Min: 0.1, Max: 0.2, Steps: 101}, tradeAxis{Field: "m", Min: 200, Max: 300, Steps: 100})
+	if err == nil {
+		t.Fatal("expected an error for a grid just over the cell limit")
+	}
+}
+
+func TestTradeStudyHandlerWritesCSV(t *testing.T) {
+	body, err := json.Marshal(tradeStudyRequest{
+		Inputs: defaultSimulationInputs(),
+		X:      tradeAxis{Field: "m", Min: 250, Max: 350, Steps: 2},
+		Y:      tradeAxis{Field: "a", Min: 0.8, Max: 1.2, Steps: 3},
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/tradestudy?format=csv", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	tradeStudyHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("got content type %q, want text/csv", ct)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll returned error: %v", err)
+	}
+	if len(rows) != 1+2*3 || rows[0][0] != "m" || rows[0][1] != "a" {
+		t.Fatalf("got %d rows with header %v, want a header and 6 cells", len(rows), rows[0])
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// weatherArchiveURL is the archive API used to replay past race days. The
+// server's -archive-url flag overrides it (for a mirror or a local stand-in).
+var weatherArchiveURL = "https://archive-api.open-meteo.com/v1/archive"
+
+// weatherArchiveDir is where archive files named in requests are looked up.
+const weatherArchiveDir = "data/weather"
+
+// archiveOrientationTolDeg absorbs the rounding in zoneOrientation when
+// matching a window or zone to an archive file's orientation.
+const archiveOrientationTolDeg = 0.5
+
+// weatherArchive selects recorded weather instead of the forecast.
+// With File empty, hourly data is fetched from weatherArchiveURL for the days
+// being simulated, transposed by the archive for each array orientation.
+// With File set, the named file in data/weather holds GTI for the single
+// orientation TiltDeg/AzimuthDeg (default: the race window's); the file has
+// no GHI/DNI/DHI to transpose, so a race window or zone facing any other way
+// is rejected and a charge window facing any other way is skipped.
+type weatherArchive struct {
+	File       string   `json:"file,omitempty"`       // Open-Meteo JSON or CSV (time,gti,temp,wind)
+	TiltDeg    *float64 `json:"tiltDeg,omitempty"`    // orientation the file's GTI was recorded for
+	AzimuthDeg *float64 `json:"azimuthDeg,omitempty"` // 0 = south, -90 = east, like chargeWindow
+}
+
+// checkOrientation reports whether the archive can replay GTI for an array
+// at tiltDeg/azimuthDeg.
+func (a weatherArchive) checkOrientation(tiltDeg, azimuthDeg float64) error {
+	if a.File == "" {
+		return nil
+	}
+	fileTilt, fileAzimuth := raceWindowTiltDeg, raceWindowAzimuthDeg
+	if a.TiltDeg != nil {
+		fileTilt = *a.TiltDeg
+	}
+	if a.AzimuthDeg != nil {
+		fileAzimuth = *a.AzimuthDeg
+	}
+	dAz := math.Mod(math.Abs(azimuthDeg-fileAzimuth), 360)
+	dAz = math.Min(dAz, 360-dAz)
+	// azimuth is meaningless for a flat array
+	if math.Abs(tiltDeg-fileTilt) <= archiveOrientationTolDeg && (dAz <= archiveOrientationTolDeg || fileTilt == 0) {
+		return nil
+	}
+	return archiveOrientationError{a.File, fileTilt, fileAzimuth, tiltDeg, azimuthDeg}
+}
+
+// archiveOrientationError is an array orientation an archive file cannot
+// replay.
+type archiveOrientationError struct {
+	File                  string
+	FileTilt, FileAzimuth float64
+	TiltDeg, AzimuthDeg   float64
+}
+
+func (e archiveOrientationError) Error() string {
+	return fmt.Sprintf("archive file %q holds GTI for tilt %g° azimuth %g° and cannot replay an array at tilt %.1f° azimuth %.1f°; use the archive API (no file) or match the orientation",
+		e.File, e.FileTilt, e.FileAzimuth, e.TiltDeg, e.AzimuthDeg)
+}
+
+// FetchArchiveHourlyWeather calls an Open-Meteo-compatible archive endpoint and
+// returns recorded hourly GTI, temperature and wind for [startDay, endDay].
+func FetchArchiveHourlyWeather(endpoint string, lat, lon, tiltDeg, azimuthDeg float64, tz string, startDay, endDay time.Time) (HourlyWeather, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return HourlyWeather{}, fmt.Errorf("invalid archive endpoint: %w", err)
+	}
+	q := base.Query()
+
+	q.Set("latitude", strconv.FormatFloat(lat, 'f', 6, 64))
+	q.Set("longitude", strconv.FormatFloat(lon, 'f', 6, 64))
+	q.Set("hourly", "global_tilted_irradiance_instant,temperature_2m,wind_speed_10m")
+	q.Set("wind_speed_unit", "ms")
+	q.Set("tilt", strconv.FormatFloat(tiltDeg, 'f', 2, 64))
+	q.Set("azimuth", strconv.FormatFloat(azimuthDeg, 'f', 2, 64))
+	q.Set("timezone", tz)
+	q.Set("start_date", startDay.Format("2006-01-02"))
+	q.Set("end_date", endDay.Format("2006-01-02"))
+	base.RawQuery = q.Encode()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(base.String())
+	if err != nil {
+		return HourlyWeather{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return HourlyWeather{}, fmt.Errorf("weather archive error: %s", resp.Status)
+	}
+
+	return decodeOpenMeteoWeather(resp.Body, tz)
+}
+
+// LoadHourlyWeatherFile reads a saved hourly dataset. ".json" files use the
+// Open-Meteo response layout; ".csv" files have a header row followed by
+// time (2006-01-02T15:04, local to tz), GTI [W/m²], temperature [°C] and
+// wind speed [m/s] columns.
+func LoadHourlyWeatherFile(path string, tz string) (HourlyWeather, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return HourlyWeather{}, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return decodeOpenMeteoWeather(f, tz)
+	case ".csv":
+		return decodeWeatherCSV(f, tz)
+	default:
+		return HourlyWeather{}, fmt.Errorf("unsupported weather file %q: want .json or .csv", filepath.Base(path))
+	}
+}
+
+func decodeWeatherCSV(r io.Reader, tz string) (HourlyWeather, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return HourlyWeather{}, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return HourlyWeather{}, err
+	}
+	if len(rows) < 2 {
+		return HourlyWeather{}, fmt.Errorf("weather CSV has no data rows")
+	}
+
+	var weather HourlyWeather
+	for i, row := range rows[1:] {
+		if len(row) < 4 {
+			return HourlyWeather{}, fmt.Errorf("weather CSV row %d: want 4 columns, got %d", i+2, len(row))
+		}
+		t, err := time.ParseInLocation("2006-01-02T15:04", row[0], loc)
+		if err != nil {
+			return HourlyWeather{}, fmt.Errorf("weather CSV row %d: %w", i+2, err)
+		}
+		values := make([]float64, 3)
+		for j := range values {
+			values[j], err = strconv.ParseFloat(row[j+1], 64)
+			if err != nil {
+				return HourlyWeather{}, fmt.Errorf("weather CSV row %d: %w", i+2, err)
+			}
+		}
+		weather.Times = append(weather.Times, t)
+		weather.GTI = append(weather.GTI, values[0])
+		weather.TempC = append(weather.TempC, values[1])
+		weather.WindMPS = append(weather.WindMPS, values[2])
+	}
+	return weather, nil
+}
+
+// archiveFilePath resolves a requested archive file name inside
+// weatherArchiveDir. Directory parts are dropped so requests can't read
+// anything outside it.
+func archiveFilePath(name string) string {
+	return filepath.Join(weatherArchiveDir, filepath.Base(name))
+}
+
+// fetchArchiveWeather is the archive source used by the energy builders.
+// Tests swap it for a canned series.
+var fetchArchiveWeather = func(site solarSite, tiltDeg, azimuthDeg float64, window chargeWindow) (HourlyWeather, error) {
+	if site.Archive.File != "" {
+		return LoadHourlyWeatherFile(archiveFilePath(site.Archive.File), site.Timezone)
+	}
+	return FetchArchiveHourlyWeather(weatherArchiveURL, site.Lat, site.Lon, tiltDeg, azimuthDeg, site.Timezone, window.Start, window.End)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadHourlyWeatherFileReadsCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "race.csv")
+	data := "time,gti,temp,wind\n2025-07-10T09:00,650,29.5,2.1\n2025-07-10T10:00,800,31,3.4\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+
+	got, err := LoadHourlyWeatherFile(path, "America/New_York")
+	if err != nil {
+		t.Fatalf("LoadHourlyWeatherFile returned error: %v", err)
+	}
+	if len(got.Times) != 2 || got.GTI[1] != 800 || got.TempC[0] != 29.5 || got.WindMPS[1] != 3.4 {
+		t.Fatalf("got %+v, want two parsed rows", got)
+	}
+	if got.Times[0].Hour() != 9 || got.Times[0].Location().String() != "America/New_York" {
+		t.Fatalf("got first time %v, want 09:00 America/New_York", got.Times[0])
+	}
+}
+
+func TestFetchArchiveHourlyWeatherRequestsDateRange(t *testing.T) {
+	var gotQuery map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		fmt.Fprint(w, `{"hourly":{"time":["2025-07-10T09:00"],"global_tilted_irradiance_instant":[700],"temperature_2m":[30],"wind_speed_10m":[2]}}`)
+	}))
+	defer srv.Close()
+
+	day := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	got, err := FetchArchiveHourlyWeather(srv.URL, 29.65, -82.32, 5, 0, "UTC", day, day)
+	if err != nil {
+		t.Fatalf("FetchArchiveHourlyWeather returned error: %v", err)
+	}
+	if len(got.GTI) != 1 || got.GTI[0] != 700 || got.TempC[0] != 30 {
+		t.Fatalf("got %+v, want the archived hour", got)
+	}
+	if gotQuery["start_date"][0] != "2025-07-10" || gotQuery["end_date"][0] != "2025-07-10" {
+		t.Fatalf("got query %v, want start/end date 2025-07-10", gotQuery)
+	}
+}
+
+func TestSelectWeatherForDaysReplaysPastDates(t *testing.T) {
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	site := testSolarSite()
+	if err := selectWeatherForDays(&site, now.AddDate(-1, 0, 0), 3, now); err != nil {
+		t.Fatalf("selectWeatherForDays returned error: %v", err)
+	}
+	if site.Archive == nil {
+		t.Fatal("expected past race days to use the weather archive")
+	}
+
+	site = testSolarSite()
+	if err := selectWeatherForDays(&site, now.AddDate(0, 0, -1), 3, now); err == nil {
+		t.Fatal("expected error for race days spanning past and forecast weather")
+	}
+}
+
+func TestSimulateRaceDayReplaysArchivedWeather(t *testing.T) {
+	day := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	prev := fetchArchiveWeather
+	t.Cleanup(func() { fetchArchiveWeather = prev })
+	fetchArchiveWeather = func(site solarSite, tiltDeg, azimuthDeg float64, window chargeWindow) (HourlyWeather, error) {
+		var weather HourlyWeather
+		for h := 0; h < 24; h++ {
+			weather.Times = append(weather.Times, day.Add(time.Duration(h)*time.Hour))
+			weather.GTI = append(weather.GTI, 500)
+		}
+		return weather, nil
+	}
+
+	site := testSolarSite()
+	site.Archive = &weatherArchive{}
+	got, err := simulateRaceDay(site, defaultRaceDaySchedule(day, time.UTC), defaultSimulationInputs(), 0)
+	if err != nil {
+		t.Fatalf("simulateRaceDay returned error: %v", err)
+	}
+
+	want := 500 * site.PanelArea * site.PanelEff * site.SystemEff * 8
+	if math.Abs(got.RaceSolarWh-want) > 1e-9 {
+		t.Fatalf("got race solar %.6f Wh, want %.6f Wh from the archive", got.RaceSolarWh, want)
+	}
+}
+
+func TestArchiveFileRejectsOtherOrientations(t *testing.T) {
+	day := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	prev := fetchArchiveWeather
+	t.Cleanup(func() { fetchArchiveWeather = prev })
+	fetchArchiveWeather = func(site solarSite, tiltDeg, azimuthDeg float64, window chargeWindow) (HourlyWeather, error) {
+		var weather HourlyWeather
+		for h := 0; h < 24; h++ {
+			weather.Times = append(weather.Times, day.Add(time.Duration(h)*time.Hour))
+			weather.GTI = append(weather.GTI, 500)
+		}
+		return weather, nil
+	}
+
+	site := testSolarSite()
+	site.Archive = &weatherArchive{File: "race.csv"}
+	schedule := defaultRaceDaySchedule(day, time.UTC)
+	if _, err := windowZoneEnergy(site, schedule.Race); err != nil {
+		t.Fatalf("windowZoneEnergy returned error for the race window: %v", err)
+	}
+	if _, err := windowZoneEnergy(site, schedule.PreRace); err == nil {
+		t.Fatal("expected an error replaying a tilted charging window from a race-tilt file")
+	}
+
+	site.Zones = []arrayZone{{Name: "top", Area: 3.0, CellEff: 0.24}, {Name: "canopy", Area: 0.3, CellEff: 0.20, TiltDeg: 30, AzimuthDeg: 90}}
+	if _, err := windowZoneEnergy(site, schedule.Race); err == nil {
+		t.Fatal("expected an error replaying a tilted zone from a race-tilt file")
+	}
+
+	tilt, azimuth := 45.0, -90.0
+	site.Zones = nil
+	site.Archive = &weatherArchive{File: "morning.csv", TiltDeg: &tilt, AzimuthDeg: &azimuth}
+	if _, err := windowZoneEnergy(site, schedule.PreRace); err != nil {
+		t.Fatalf("windowZoneEnergy returned error for a file recorded at the window's orientation: %v", err)
+	}
+}
+
+func TestSimulateMultiDaySkipsChargeWindowsAnArchiveFileCannotReplay(t *testing.T) {
+	day := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	prev := fetchArchiveWeather
+	t.Cleanup(func() { fetchArchiveWeather = prev })
+	fetchArchiveWeather = func(site solarSite, tiltDeg, azimuthDeg float64, window chargeWindow) (HourlyWeather, error) {
+		var weather HourlyWeather
+		for h := 0; h < 48; h++ {
+			weather.Times = append(weather.Times, day.Add(time.Duration(h)*time.Hour))
+			weather.GTI = append(weather.GTI, 500)
+		}
+		return weather, nil
+	}
+
+	site := testSolarSite()
+	site.Archive = &weatherArchive{File: "race.csv"}
+	inputs := defaultSimulationInputs()
+	got, err := simulateMultiDay(site, defaultMultiDaySchedules(day, 2), inputs, inputs.BatteryWh, 0)
+	if err != nil {
+		t.Fatalf("simulateMultiDay returned error: %v", err)
+	}
+	want := 500 * site.PanelArea * site.PanelEff * site.SystemEff * 8
+	for _, d := range got {
+		if d.PreRaceChargeWh != 0 || d.PostRaceChargeWh != 0 || len(d.SkippedWindows) != 2 {
+			t.Fatalf("day %d: got charge %.3f/%.3f Wh skipping %v, want both charge windows skipped", d.Day, d.PreRaceChargeWh, d.PostRaceChargeWh, d.SkippedWindows)
+		}
+		if math.Abs(d.RaceSolarWh-want) > 1e-9 {
+			t.Fatalf("day %d: got race solar %.6f Wh, want %.6f Wh from the file", d.Day, d.RaceSolarWh, want)
+		}
+	}
+
+	// a race-window zone the file cannot replay is still an error, and a 400
+	site.Zones = []arrayZone{{Name: "top", Area: 3.0, CellEff: 0.24}, {Name: "canopy", Area: 0.3, CellEff: 0.20, TiltDeg: 30, AzimuthDeg: 90}}
+	_, err = simulateMultiDay(site, defaultMultiDaySchedules(day, 1), inputs, inputs.BatteryWh, 0)
+	if err == nil {
+		t.Fatal("expected an error replaying a tilted race zone from a race-tilt file")
+	}
+	if got := weatherErrorStatus(err); got != http.StatusBadRequest {
+		t.Fatalf("got status %d for %v, want %d", got, err, http.StatusBadRequest)
+	}
+}